@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	approvalCheckInterval = 10 * time.Second
+)
+
+var (
+	approvalFilePath string
+)
+
+func initApprovalFile(instance string) {
+	if instance != "" {
+		instance = "_" + instance
+	}
+	approvalFilePath = fmt.Sprintf("rcgdip_approve_quarantine%s", instance)
+	// An approval must be given while running, for the quarantine it sees
+	if _, err := os.Stat(approvalFilePath); err == nil {
+		if err = os.Remove(approvalFilePath); err != nil {
+			logger.Errorf("[Main] failed to remove the leftover quarantine approval file '%s': %s", approvalFilePath, err)
+		} else {
+			logger.Warningf("[Main] leftover quarantine approval file '%s' removed: create it again to approve the quarantined scans", approvalFilePath)
+		}
+	}
+}
+
+func watchApprovalFile() {
+	ticker := time.NewTicker(approvalCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := os.Stat(approvalFilePath); err != nil {
+				if !os.IsNotExist(err) {
+					logger.Errorf("[Main] failed to check the quarantine approval file '%s': %s", approvalFilePath, err)
+				}
+				continue
+			}
+			// Consume the approval before using it: it must not be applied twice
+			if err := os.Remove(approvalFilePath); err != nil {
+				logger.Errorf("[Main] failed to remove the quarantine approval file '%s', ignoring it: %s", approvalFilePath, err)
+				continue
+			}
+			approveQuarantine()
+		case <-mainCtx.Done():
+			return
+		}
+	}
+}
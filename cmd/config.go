@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hekmon/rcgdip/plex"
+
 	"github.com/hekmon/hllogger/v2"
 	"github.com/rclone/rclone/vfs/vfscommon"
 )
@@ -18,8 +21,25 @@ const (
 	rcloneDriveDirCacheTimelEnvName = "RCGDIP_RCLONE_BACKEND_DRIVE_DIRCACHETIME"
 	rcloneCryptackendNameEnvName    = "RCGDIP_RCLONE_BACKEND_CRYPT_NAME"
 	rcloneMountPathEnvName          = "RCGDIP_RCLONE_MOUNT_PATH"
+	rcloneMountRootsEnvName         = "RCGDIP_RCLONE_MOUNT_ROOTS"
+	drivePushURLEnvName             = "RCGDIP_DRIVE_PUSH_URL"
+	drivePushListenEnvName          = "RCGDIP_DRIVE_PUSH_LISTEN"
+	rcloneRCURLEnvName              = "RCGDIP_RCLONE_RC_URL"
+	rcloneRCUserEnvName             = "RCGDIP_RCLONE_RC_USER"
+	rcloneRCPassEnvName             = "RCGDIP_RCLONE_RC_PASS"
+	rcloneRCFSEnvName               = "RCGDIP_RCLONE_RC_FS"
 	plexURLEnvName                  = "RCGDIP_PLEX_URL"
 	plexTokenEnvName                = "RCGDIP_PLEX_TOKEN"
+	plexVerifyMountEnvName          = "RCGDIP_PLEX_VERIFY_MOUNT"
+	plexMountGuardEnvName           = "RCGDIP_PLEX_MOUNT_GUARD"
+	plexMountCanaryEnvName          = "RCGDIP_PLEX_MOUNT_CANARY"
+	plexPathMappingEnvName          = "RCGDIP_PLEX_PATH_MAPPING"
+	plexLibrariesIncludeEnvName     = "RCGDIP_PLEX_LIBRARIES_INCLUDE"
+	plexLibrariesExcludeEnvName     = "RCGDIP_PLEX_LIBRARIES_EXCLUDE"
+	plexDeletionBrakeCountEnvName   = "RCGDIP_PLEX_DELETION_BRAKE_COUNT"
+	plexDeletionBrakePctEnvName     = "RCGDIP_PLEX_DELETION_BRAKE_PERCENT"
+	plexMaxConcurrentScansEnvName   = "RCGDIP_PLEX_MAX_CONCURRENT_SCANS"
+	plexScansPerMinuteEnvName       = "RCGDIP_PLEX_SCANS_PER_MINUTE"
 	logLevelEnvName                 = "RCGDIP_LOGLEVEL"
 )
 
@@ -30,8 +50,24 @@ var (
 	rcloneDriveDirCacheTime time.Duration
 	rcloneCryptName         string
 	rcloneMountPath         string
+	rcloneMountRoots        []plex.MountRoot
+	drivePushURL            string
+	drivePushListen         string
+	rcloneRCURL             *url.URL
+	rcloneRCUser            string
+	rcloneRCPass            string
+	rcloneRCFS              string
 	plexURL                 *url.URL
 	plexToken               string
+	plexVerifyMount         bool
+	plexMountGuard          bool
+	plexMountCanary         string
+	plexPathMappings        []plex.PathMapping
+	plexLibraries           plex.LibrarySelection
+	plexDeletionBrakeCount  int
+	plexDeletionBrakePct    float64
+	plexMaxConcurrentScans  int
+	plexScansPerMinute      int
 	logLevel                hllogger.LogLevel
 )
 
@@ -77,12 +113,68 @@ func populateConfig() (err error) {
 		// use rclone default
 		rcloneDriveDirCacheTime = vfscommon.DefaultOpt.DirCacheTime
 	}
-	// mount path
-	if rcloneMountPath = os.Getenv(rcloneMountPathEnvName); rcloneMountPath == "" {
-		return fmt.Errorf("%s must be set", rcloneMountPath)
+	// mount path(s)
+	rcloneMountPath = os.Getenv(rcloneMountPathEnvName)
+	if mountRootsStr := os.Getenv(rcloneMountRootsEnvName); mountRootsStr != "" {
+		if rcloneMountPath != "" {
+			return fmt.Errorf("%s and %s can not be both set", rcloneMountPathEnvName, rcloneMountRootsEnvName)
+		}
+		for _, root := range strings.Split(mountRootsStr, ",") {
+			paths := strings.SplitN(strings.TrimSpace(root), ":", 2)
+			if len(paths) != 2 || paths[1] == "" {
+				return fmt.Errorf("%s: invalid mount root '%s': expecting 'remote/sub/path:/local/mount/point'", rcloneMountRootsEnvName, root)
+			}
+			if paths[1][0] != '/' {
+				return fmt.Errorf("%s: local mount point '%s' must be absolute (it must start by '/')", rcloneMountRootsEnvName, paths[1])
+			}
+			rcloneMountRoots = append(rcloneMountRoots, plex.MountRoot{
+				Remote: paths[0],
+				Local:  paths[1],
+			})
+		}
+	} else {
+		if rcloneMountPath == "" {
+			return fmt.Errorf("%s (or %s) must be set", rcloneMountPathEnvName, rcloneMountRootsEnvName)
+		}
+		if rcloneMountPath[0] != '/' {
+			return fmt.Errorf("%s must be absolute (it must start by '/')", rcloneMountPathEnvName)
+		}
+	}
+	// rclone remote control
+	if rcloneRCURLStr := os.Getenv(rcloneRCURLEnvName); rcloneRCURLStr != "" {
+		if rcloneRCURL, err = url.Parse(rcloneRCURLStr); err != nil {
+			return fmt.Errorf("failed to parse %s value as URL: %s", rcloneRCURLEnvName, err)
+		}
+		rcloneRCUser = os.Getenv(rcloneRCUserEnvName)
+		rcloneRCPass = os.Getenv(rcloneRCPassEnvName)
+		rcloneRCFS = os.Getenv(rcloneRCFSEnvName)
+		// Each mount root is its own VFS: refreshing them needs to target each of them
+		if len(rcloneMountRoots) > 1 {
+			remotes := strings.Split(rcloneRCFS, ",")
+			if rcloneRCFS == "" || len(remotes) != len(rcloneMountRoots) {
+				return fmt.Errorf("%s must contain one remote per mount root of %s (%d expected, in the same order, eg 'gdrive:Movies,gdrive:TV')",
+					rcloneRCFSEnvName, rcloneMountRootsEnvName, len(rcloneMountRoots))
+			}
+			for index, remote := range remotes {
+				if rcloneMountRoots[index].RCFS = strings.TrimSpace(remote); rcloneMountRoots[index].RCFS == "" {
+					return fmt.Errorf("%s: empty remote for mount root '%s'", rcloneRCFSEnvName, rcloneMountRoots[index].Local)
+				}
+			}
+			rcloneRCFS = ""
+		}
 	}
-	if rcloneMountPath[0] != '/' {
-		return fmt.Errorf("%s must be absolute (it must start by '/')", rcloneMountPath)
+	// drive push notifications
+	if drivePushURL = os.Getenv(drivePushURLEnvName); drivePushURL != "" {
+		var pushURL *url.URL
+		if pushURL, err = url.Parse(drivePushURL); err != nil {
+			return fmt.Errorf("failed to parse %s value as URL: %s", drivePushURLEnvName, err)
+		}
+		if pushURL.Scheme != "https" {
+			return fmt.Errorf("%s must be an https URL", drivePushURLEnvName)
+		}
+		if drivePushListen = os.Getenv(drivePushListenEnvName); drivePushListen == "" {
+			return fmt.Errorf("%s must be set when %s is set", drivePushListenEnvName, drivePushURLEnvName)
+		}
 	}
 	// plex url
 	plexURLStr := os.Getenv(plexURLEnvName)
@@ -96,6 +188,61 @@ func populateConfig() (err error) {
 	if plexToken = os.Getenv(plexTokenEnvName); plexToken == "" {
 		return fmt.Errorf("%s must be set", plexTokenEnvName)
 	}
+	// plex mount verification
+	if verifyMountStr := os.Getenv(plexVerifyMountEnvName); verifyMountStr != "" {
+		if plexVerifyMount, err = strconv.ParseBool(verifyMountStr); err != nil {
+			return fmt.Errorf("failed to parse %s as boolean: %s", plexVerifyMountEnvName, err)
+		}
+	}
+	// plex mount guard
+	if mountGuardStr := os.Getenv(plexMountGuardEnvName); mountGuardStr != "" {
+		if plexMountGuard, err = strconv.ParseBool(mountGuardStr); err != nil {
+			return fmt.Errorf("failed to parse %s as boolean: %s", plexMountGuardEnvName, err)
+		}
+	}
+	if plexMountCanary = os.Getenv(plexMountCanaryEnvName); plexMountCanary != "" {
+		if !plexMountGuard {
+			return fmt.Errorf("%s is set but %s is not enabled", plexMountCanaryEnvName, plexMountGuardEnvName)
+		}
+		if plexMountCanary[0] == '/' {
+			return fmt.Errorf("%s must be relative to the mount point(s)", plexMountCanaryEnvName)
+		}
+	}
+	// plex path mapping
+	if pathMappingStr := os.Getenv(plexPathMappingEnvName); pathMappingStr != "" {
+		for _, rule := range strings.Split(pathMappingStr, ",") {
+			paths := strings.SplitN(strings.TrimSpace(rule), ":", 2)
+			if len(paths) != 2 || paths[0] == "" || paths[1] == "" {
+				return fmt.Errorf("%s: invalid rule '%s': expecting '/local/path:/plex/path'", plexPathMappingEnvName, rule)
+			}
+			plexPathMappings = append(plexPathMappings, plex.PathMapping{
+				Local: paths[0],
+				Plex:  paths[1],
+			})
+		}
+	}
+	// plex libraries selection
+	plexLibraries.Include = parseOptionalList(plexLibrariesIncludeEnvName)
+	plexLibraries.Exclude = parseOptionalList(plexLibrariesExcludeEnvName)
+	// plex mass deletion brake
+	if plexDeletionBrakeCount, err = parseOptionalPositiveInt(plexDeletionBrakeCountEnvName); err != nil {
+		return
+	}
+	if deletionBrakePctStr := os.Getenv(plexDeletionBrakePctEnvName); deletionBrakePctStr != "" {
+		if plexDeletionBrakePct, err = strconv.ParseFloat(deletionBrakePctStr, 64); err != nil {
+			return fmt.Errorf("failed to parse %s as float: %s", plexDeletionBrakePctEnvName, err)
+		}
+		if plexDeletionBrakePct < 0 || plexDeletionBrakePct > 100 {
+			return fmt.Errorf("%s (%v) must be between 0 and 100", plexDeletionBrakePctEnvName, plexDeletionBrakePct)
+		}
+	}
+	// plex scans throttling
+	if plexMaxConcurrentScans, err = parseOptionalPositiveInt(plexMaxConcurrentScansEnvName); err != nil {
+		return
+	}
+	if plexScansPerMinute, err = parseOptionalPositiveInt(plexScansPerMinuteEnvName); err != nil {
+		return
+	}
 	// log level
 	switch strings.ToUpper(os.Getenv(logLevelEnvName)) {
 	case "ERROR":
@@ -114,6 +261,30 @@ func populateConfig() (err error) {
 	return
 }
 
+func parseOptionalPositiveInt(envName string) (value int, err error) {
+	valueStr := os.Getenv(envName)
+	if valueStr == "" {
+		return
+	}
+	if value, err = strconv.Atoi(valueStr); err != nil {
+		err = fmt.Errorf("failed to parse %s as integer: %s", envName, err)
+		return
+	}
+	if value < 0 {
+		err = fmt.Errorf("%s (%d) can not be negative", envName, value)
+	}
+	return
+}
+
+func parseOptionalList(envName string) (values []string) {
+	for _, value := range strings.Split(os.Getenv(envName), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return
+}
+
 func debugConf() {
 	logger.Debugf("[Main] %s: %s", rcloneConfigPathEnvName, rcloneConfigPath)
 	logger.Debugf("[Main] %s: %s", rcloneDriveBackendNameEnvName, rcloneDriveName)
@@ -121,6 +292,27 @@ func debugConf() {
 	logger.Debugf("[Main] %s: %v", rcloneDriveDirCacheTimelEnvName, rcloneDriveDirCacheTime)
 	logger.Debugf("[Main] %s: %v", rcloneCryptackendNameEnvName, rcloneCryptName)
 	logger.Debugf("[Main] %s: %v", rcloneMountPathEnvName, rcloneMountPath)
+	logger.Debugf("[Main] %s: %v", rcloneMountRootsEnvName, rcloneMountRoots)
+	if rcloneRCURL != nil {
+		logger.Debugf("[Main] %s: %v", rcloneRCURLEnvName, rcloneRCURL.String())
+	} else {
+		logger.Debugf("[Main] %s: <not set>", rcloneRCURLEnvName)
+	}
+	logger.Debugf("[Main] %s: %v", rcloneRCUserEnvName, rcloneRCUser)
+	logger.Debugf("[Main] %s: <redacted>", rcloneRCPassEnvName)
+	logger.Debugf("[Main] %s: %v", rcloneRCFSEnvName, rcloneRCFS)
+	logger.Debugf("[Main] %s: %v", drivePushURLEnvName, drivePushURL)
+	logger.Debugf("[Main] %s: %v", drivePushListenEnvName, drivePushListen)
 	logger.Debugf("[Main] %s: %v", plexURLEnvName, plexURL.String())
 	logger.Debugf("[Main] %s: <redacted>", plexTokenEnvName)
+	logger.Debugf("[Main] %s: %v", plexVerifyMountEnvName, plexVerifyMount)
+	logger.Debugf("[Main] %s: %v", plexMountGuardEnvName, plexMountGuard)
+	logger.Debugf("[Main] %s: %v", plexMountCanaryEnvName, plexMountCanary)
+	logger.Debugf("[Main] %s: %v", plexPathMappingEnvName, plexPathMappings)
+	logger.Debugf("[Main] %s: %v", plexLibrariesIncludeEnvName, plexLibraries.Include)
+	logger.Debugf("[Main] %s: %v", plexLibrariesExcludeEnvName, plexLibraries.Exclude)
+	logger.Debugf("[Main] %s: %d", plexDeletionBrakeCountEnvName, plexDeletionBrakeCount)
+	logger.Debugf("[Main] %s: %v", plexDeletionBrakePctEnvName, plexDeletionBrakePct)
+	logger.Debugf("[Main] %s: %d", plexMaxConcurrentScansEnvName, plexMaxConcurrentScans)
+	logger.Debugf("[Main] %s: %d", plexScansPerMinuteEnvName, plexScansPerMinute)
 }
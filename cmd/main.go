@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 
-	"github.com/hekmon/rcgdip/drivechange"
 	"github.com/hekmon/rcgdip/gdrive"
 	"github.com/hekmon/rcgdip/gdrive/rcsnooper"
 	"github.com/hekmon/rcgdip/plex"
@@ -27,6 +26,7 @@ var (
 	// Controllers
 	logger        *hllogger.Logger
 	db            *storage.Controller
+	changesQueue  *storage.ChangesQueue
 	driveWatcher  *gdrive.Controller
 	plexTriggerer *plex.Controller
 	// Clean stop
@@ -82,8 +82,13 @@ func main() {
 	}
 	logger.Info("[Main] storage backend ready")
 
-	// Prepare the communication channel
-	changesChan := make(chan []drivechange.File)
+	// Prepare the durable communication queue
+	if changesQueue, err = db.NewChangesQueue("changes_queue"); err != nil {
+		logger.Errorf("[Main] failed to initialize the changes queue: %s", err.Error())
+		killSwtich(1)
+		<-mainStop
+		os.Exit(exitCode)
+	}
 
 	// Initialize GDrive controller
 	logger.Info("[Main] initializing the Google Drive watcher...")
@@ -94,11 +99,14 @@ func main() {
 			CryptBackendName: rcloneCryptName,
 		},
 		PollInterval: rcloneDrivePollInterval,
+		PushURL:      drivePushURL,
+		PushListen:   drivePushListen,
+		CountRemoved: plexDeletionBrakeCount > 0 || plexDeletionBrakePct > 0,
 		Logger:       logger,
 		StateBackend: db.NewScoppedAccess("drive_state"),
 		IndexBackend: db.NewScoppedAccess("drive_index"),
 		KillSwitch:   func() { killSwtich(4) },
-		Output:       changesChan,
+		Output:       changesQueue,
 	}); err != nil {
 		logger.Errorf("[Main] failed to initialize the Google Drive watcher: %s", err.Error())
 		killSwtich(2)
@@ -110,16 +118,30 @@ func main() {
 	// Initialize the Plex controller
 	logger.Info("[Main] initializing the Plex Triggerer...")
 	if plexTriggerer, err = plex.New(mainCtx, plex.Config{
-		Input:          changesChan,
-		PollInterval:   rcloneDrivePollInterval,
-		DirCacheTime:   rcloneDriveDirCacheTime,
-		MountPoint:     rcloneMountPath,
-		PlexURL:        plexURL,
-		PlexToken:      plexToken,
-		ProductName:    appName,
-		ProductVersion: appVersion,
-		StateBackend:   db.NewScoppedAccess("plex_state"),
-		Logger:         logger,
+		Input:                changesQueue,
+		PollInterval:         rcloneDrivePollInterval,
+		DirCacheTime:         rcloneDriveDirCacheTime,
+		MountPoint:           rcloneMountPath,
+		MountRoots:           rcloneMountRoots,
+		VerifyMount:          plexVerifyMount,
+		MountGuard:           plexMountGuard,
+		MountCanary:          plexMountCanary,
+		PathMappings:         plexPathMappings,
+		Libraries:            plexLibraries,
+		DeletionBrakeCount:   plexDeletionBrakeCount,
+		DeletionBrakePercent: plexDeletionBrakePct,
+		MaxConcurrentScans:   plexMaxConcurrentScans,
+		ScansPerMinute:       plexScansPerMinute,
+		RCloneRCURL:          rcloneRCURL,
+		RCloneRCUser:         rcloneRCUser,
+		RCloneRCPass:         rcloneRCPass,
+		RCloneRCFS:           rcloneRCFS,
+		PlexURL:              plexURL,
+		PlexToken:            plexToken,
+		ProductName:          appName,
+		ProductVersion:       appVersion,
+		StateBackend:         db.NewScoppedAccess("plex_state"),
+		Logger:               logger,
 	}); err != nil {
 		logger.Errorf("[Main] failed to initialize the Plex Triggerer: %s", err.Error())
 		killSwtich(3)
@@ -127,6 +149,8 @@ func main() {
 		os.Exit(exitCode)
 	}
 	logger.Info("[Main] Plex Triggerer started")
+	initApprovalFile(*flagInstance)
+	go watchApprovalFile()
 
 	// We are ready
 	if err = sysdnotify.Ready(); err != nil {
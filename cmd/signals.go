@@ -12,12 +12,14 @@ func handleSignals() {
 	// Register signals
 	var sig os.Signal
 	signalChannel := make(chan os.Signal, 3)
-	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1, syscall.SIGUSR2)
 	// Waiting for signals to catch
 	for sig = range signalChannel {
 		switch sig {
 		case syscall.SIGUSR1:
 			backupDB()
+		case syscall.SIGUSR2:
+			dumpScheduledJobs()
 		case syscall.SIGTERM:
 			fallthrough
 		case syscall.SIGINT:
@@ -47,3 +49,32 @@ func backupDB() {
 		logger.Debug("[Main] systemd ready notification sent")
 	}
 }
+
+func dumpScheduledJobs() {
+	if changesQueue != nil {
+		logger.Infof("[Main] %d changes batch(es) waiting to be processed", changesQueue.Len())
+	}
+	if plexTriggerer == nil {
+		logger.Warning("[Main] Plex Triggerer is not started: no scheduled jobs to show")
+		return
+	}
+	jobs := plexTriggerer.ScheduledJobs()
+	logger.Infof("[Main] %d scan job(s) scheduled", len(jobs))
+	for index, job := range jobs {
+		logger.Infof("[Main] scheduled job #%d: scan of '%s' in '%s' at %v", index+1, job.ScanPath, job.LibName, job.ScanAt)
+	}
+	if jobs = plexTriggerer.QuarantinedJobs(); len(jobs) > 0 {
+		logger.Warningf("[Main] %d scan job(s) quarantined by the mass deletion brake, create the file '%s' to approve them", len(jobs), approvalFilePath)
+		for index, job := range jobs {
+			logger.Warningf("[Main] quarantined job #%d: scan of '%s' in '%s'", index+1, job.ScanPath, job.LibName)
+		}
+	}
+}
+
+func approveQuarantine() {
+	if plexTriggerer == nil {
+		logger.Warning("[Main] Plex Triggerer is not started: no quarantined jobs to approve")
+		return
+	}
+	logger.Noticef("[Main] %d quarantined scan job(s) approved", plexTriggerer.ApproveQuarantine())
+}
@@ -3,8 +3,11 @@ package drivechange
 import "time"
 
 type File struct {
+	ID      string // Drive fileID: the changes of the same file within a batch share it
 	Event   time.Time
 	Folder  bool
 	Deleted bool
+	Moved   bool // along with Deleted: the file has been moved or renamed away from Paths
+	Files   int  // along with Folder and Deleted: number of files the folder contained
 	Paths   []string
 }
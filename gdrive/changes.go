@@ -2,6 +2,7 @@ package gdrive
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hekmon/rcgdip/drivechange"
@@ -9,80 +10,77 @@ import (
 	"google.golang.org/api/drive/v3"
 )
 
-func (c *Controller) getFilesChanges() (changedFiles []drivechange.File, err error) {
-	// Save the start token in case something goes wrong for future retry
-	var (
-		backupStartToken string
-		found            bool
-	)
-	if found, err = c.state.Get(stateNextStartPageKey, &backupStartToken); err != nil {
-		err = fmt.Errorf("failed to get the start page token from stored state: %w", err)
-		return
-	}
-	if !found {
-		err = fmt.Errorf("start page token not found within stored state: %w", err)
+func (c *Controller) getFilesChanges(changes []*drive.Change) (changedFiles []drivechange.File, err error) {
+	if len(changes) == 0 {
 		return
 	}
-	defer func() {
-		if err != nil {
-			if setErr := c.state.Set(stateNextStartPageKey, backupStartToken); setErr != nil {
-				err = fmt.Errorf("2 errors: %w | failed to restore the startPageToken within our state: %s", err, setErr)
-				return
-			}
-		}
-	}()
-	// Get changes
 	start := time.Now()
-	changes, nextStartPage, err := c.getDriveChanges(backupStartToken)
+	// Compute the paths of the moved or renamed files before the index is updated with their new location
+	previousPaths, unchangedFolders, err := c.getPreviousPaths(changes)
 	if err != nil {
-		err = fmt.Errorf("failed to get all changes recursively: %w", err)
-		return
-	}
-	if nextStartPage != backupStartToken {
-		// if no changes, token stays the same
-		if err = c.state.Set(stateNextStartPageKey, nextStartPage); err != nil {
-			err = fmt.Errorf("failed to save the nextStartPageToken within local state: %w", err)
-			return
-		}
-	}
-	c.logger.Debugf("[Drive] %d raw change(s) recovered in %v", len(changes), time.Since(start))
-	if len(changes) == 0 {
-		c.logger.Info("[Drive] no changes detected")
+		err = fmt.Errorf("failed to compute the previous paths of the moved files: %w", err)
 		return
 	}
 	// Build the index with parents for further path computation
-	indexStart := time.Now()
 	if err = c.addChangesFilesToIndex(changes); err != nil {
 		err = fmt.Errorf("failed to build up the parent index for the %d changes retreived: %w", len(changes), err)
 		return
 	}
 	if c.logger.IsDebugShown() {
 		// NbKeys has a performance hit, call it only if we need to
-		c.logger.Debugf("[Drive] index updated in %v, currently containing %d nodes", time.Since(indexStart), c.index.NbKeys())
+		c.logger.Debugf("[Drive] index updated in %v, currently containing %d entries", time.Since(start), c.index.NbKeys())
 	}
 	// Process each event
 	processStart := time.Now()
 	changedFiles = make([]drivechange.File, 0, len(changes))
-	var fc *drivechange.File
+	var (
+		fc        *drivechange.File
+		movedAway *drivechange.File
+		nbMoved   int
+	)
 	for _, change := range changes {
 		// Transforme change into a suitable file event
 		if fc, err = c.processChange(change); err != nil {
 			err = fmt.Errorf("failed to process the %d changes retreived: %w", len(changes), err)
 			return
 		}
+		// If the file has been moved or renamed, its previous location must be handled as a deletion
+		movedAway = nil
+		if prevPaths := previousPaths[change.FileId]; len(prevPaths) > 0 {
+			if movedAway, err = c.movedAwayChange(change, prevPaths, fc); err != nil {
+				err = fmt.Errorf("failed to process the %d changes retreived: %w", len(changes), err)
+				return
+			}
+		}
+		// Folders created, moved or renamed only matter if they contain files
+		if fc != nil && fc.Folder && !fc.Deleted && !c.folderChangeMatters(change.FileId, unchangedFolders[change.FileId]) {
+			fc = nil
+		}
 		// If change is valid, add it to the return list
 		if fc != nil {
 			changedFiles = append(changedFiles, *fc)
 		}
+		if movedAway != nil {
+			changedFiles = append(changedFiles, *movedAway)
+			nbMoved++
+		}
+	}
+	if len(changedFiles)-nbMoved != len(changes) {
+		c.logger.Debugf("[Drive] filtered out %d change(s) that were not a file change", len(changes)-len(changedFiles)+nbMoved)
 	}
-	if len(changedFiles) != len(changes) {
-		c.logger.Debugf("[Drive] filtered out %d change(s) that were not a file change", len(changes)-len(changedFiles))
+	if nbMoved > 0 {
+		c.logger.Debugf("[Drive] added %d deletion change(s) for the previous location of moved or renamed files", nbMoved)
 	}
 	c.logger.Debugf("[Drive] %d raw change(s) processed in %v", len(changes), time.Since(processStart))
+	// The files within the deleted or moved away folders are not reported: count them while the index still knows them
+	if err = c.countRemovedFoldersFiles(changedFiles); err != nil {
+		err = fmt.Errorf("failed to process the %d changes retreived: %w", len(changes), err)
+		return
+	}
 	// Cleanup index now that every change has builded paths
 	for _, change := range changes {
 		if change.Removed || (change.File != nil && change.File.Trashed) {
-			if err = c.index.Delete(change.FileId); err != nil {
+			if err = c.unindexFile(change.FileId); err != nil {
 				c.logger.Errorf("[Drive] failed to delete fileID '%s' from local index after processing its removed change event: %s",
 					change.FileId, err)
 				err = nil
@@ -117,11 +115,7 @@ func (c *Controller) addChangesFilesToIndex(changes []*drive.Change) (err error)
 			continue
 		}
 		// Update index with infos
-		if err = c.index.Set(change.FileId, driveFileBasicInfo{
-			Name:    change.File.Name,
-			Folder:  change.File.MimeType == folderMimeType,
-			Parents: change.File.Parents,
-		}); err != nil {
+		if err = c.indexFile(change.FileId, newDriveFileBasicInfo(change.File)); err != nil {
 			err = fmt.Errorf("failed to saved fileID '%s' within the local index: %w", change.FileId, err)
 			return
 		}
@@ -156,7 +150,7 @@ func (c *Controller) processChange(change *drive.Change) (fc *drivechange.File,
 		return
 	}
 	// In case the file metadata was not provided within the change, extract info from our index (main case: removal)
-	fileName, fileIsFolder, fileTrashed, skip, err := c.compileFileInfosFor(change)
+	fileInfo, fileTrashed, skip, err := c.compileFileInfosFor(change)
 	if err != nil {
 		err = fmt.Errorf("failed to compile file info: %w", err)
 		return
@@ -164,6 +158,17 @@ func (c *Controller) processChange(change *drive.Change) (fc *drivechange.File,
 	if skip {
 		return
 	}
+	fileName := fileInfo.Name
+	// Google Docs not exposed by rclone are not on the mount
+	if c.hiddenDocument(fileInfo.DocType) {
+		c.logger.Debugf("[Drive] fileID '%s' is a Google Doc (%s) not exposed by rclone: skipping it", change.FileId, fileInfo.DocType)
+		return
+	}
+	// Neither are the shortcuts if rclone skips them
+	if c.hiddenShortcut(fileInfo) {
+		c.logger.Debugf("[Drive] fileID '%s' is a shortcut and rclone skips them: skipping it", change.FileId)
+		return
+	}
 	// Compute possible paths (bottom up)
 	reversedPaths, err := c.generateReversePaths(change.FileId)
 	if err != nil {
@@ -171,7 +176,31 @@ func (c *Controller) processChange(change *drive.Change) (fc *drivechange.File,
 		return
 	}
 	// Validate and reverse the paths (from bottom up to top down) to be exploitables
-	validPaths := make([]string, 0, len(reversedPaths))
+	validPaths := c.validatePaths(reversedPaths)
+	if len(validPaths) == 0 {
+		// no valid path found (because of root folder id) skipping this change
+		c.logger.Debugf("[Drive] change for file '%s' does not contain any valid path, discarding it", fileName)
+		return
+	}
+	// Convert times
+	changeTime, err := time.Parse(time.RFC3339, change.Time)
+	if err != nil {
+		err = fmt.Errorf("failed to convert change time for fileID %s, name '%s': %w", change.FileId, fileName, err)
+		return
+	}
+	// Return the consolidated info for caller
+	fc = &drivechange.File{
+		ID:      change.FileId,
+		Event:   changeTime,
+		Folder:  fileInfo.Folder,
+		Deleted: change.Removed || fileTrashed,
+		Paths:   validPaths,
+	}
+	return
+}
+
+func (c *Controller) validatePaths(reversedPaths []driveFilePath) (validPaths []string) {
+	validPaths = make([]string, 0, len(reversedPaths))
 	for _, reversedPath := range reversedPaths {
 		// If custom root folder id, search it and rewrite paths with new root
 		if c.rc.Drive.Options.RootFolderID != "" {
@@ -184,41 +213,117 @@ func (c *Controller) processChange(change *drive.Change) (fc *drivechange.File,
 		// Path valid, adding it to the list
 		validPaths = append(validPaths, reversedPath.Reverse().Path())
 	}
-	if len(validPaths) == 0 {
-		// no valid path found (because of root folder id) skipping this change
-		c.logger.Debugf("[Drive] change for file '%s' does not contain any valid path, discarding it", fileName)
+	return
+}
+
+// getPreviousPaths also returns the already known folders which have been neither moved nor renamed.
+// It must run before the index is updated with the changes: a page processed again relies on the index journal to find the previous locations.
+func (c *Controller) getPreviousPaths(changes []*drive.Change) (previousPaths map[string][]string, unchangedFolders map[string]bool, err error) {
+	previousPaths = make(map[string][]string)
+	unchangedFolders = make(map[string]bool)
+	var (
+		found         bool
+		previous      driveFileBasicInfo
+		reversedPaths []driveFilePath
+		paths         []string
+	)
+	for _, change := range changes {
+		// Only files still there can have been moved or renamed
+		if change.ChangeType != "file" || change.Removed || change.File == nil || change.File.Trashed ||
+			c.hiddenFile(newDriveFileBasicInfo(change.File)) {
+			continue
+		}
+		// Compare with what the index knows
+		previous = driveFileBasicInfo{}
+		if found, err = c.index.Get(change.FileId, &previous); err != nil {
+			err = fmt.Errorf("failed to get fileID '%s' infos from local index: %w", change.FileId, err)
+			return
+		}
+		if !found {
+			continue
+		}
+		if previous.Name == change.File.Name && sameParents(previous.Parents, change.File.Parents) {
+			if previous.Folder {
+				unchangedFolders[change.FileId] = true
+			}
+			continue
+		}
+		// Compute its paths with the not yet updated index
+		if reversedPaths, err = c.generateReversePaths(change.FileId); err != nil {
+			c.logger.Warningf("[Drive] failed to compute the previous paths of the moved or renamed fileID '%s', its previous location won't be scanned: %s",
+				change.FileId, err)
+			err = nil
+			continue
+		}
+		if paths = c.validatePaths(reversedPaths); len(paths) > 0 {
+			c.logger.Debugf("[Drive] fileID '%s' has been moved or renamed from: %s", change.FileId, strings.Join(paths, ", "))
+			previousPaths[change.FileId] = paths
+		}
+	}
+	return
+}
+
+func sameParents(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+parents:
+	for _, parentA := range a {
+		for _, parentB := range b {
+			if parentA == parentB {
+				continue parents
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (c *Controller) movedAwayChange(change *drive.Change, previousPaths []string, current *drivechange.File) (fc *drivechange.File, err error) {
+	// Only keep the paths the file has left
+	leftPaths := make([]string, 0, len(previousPaths))
+previous:
+	for _, previousPath := range previousPaths {
+		if current != nil {
+			for _, currentPath := range current.Paths {
+				if currentPath == previousPath {
+					continue previous
+				}
+			}
+		}
+		leftPaths = append(leftPaths, previousPath)
+	}
+	if len(leftPaths) == 0 {
 		return
 	}
 	// Convert times
 	changeTime, err := time.Parse(time.RFC3339, change.Time)
 	if err != nil {
-		err = fmt.Errorf("failed to convert change time for fileID %s, name '%s': %w", change.FileId, fileName, err)
+		err = fmt.Errorf("failed to convert change time for fileID %s, name '%s': %w", change.FileId, change.File.Name, err)
 		return
 	}
-	// Return the consolidated info for caller
+	// Return the previous location as a deletion
 	fc = &drivechange.File{
+		ID:      change.FileId,
 		Event:   changeTime,
-		Folder:  fileIsFolder,
-		Deleted: change.Removed || fileTrashed,
-		Paths:   validPaths,
+		Folder:  newDriveFileBasicInfo(change.File).Folder,
+		Deleted: true,
+		Moved:   true,
+		Paths:   leftPaths,
 	}
 	return
 }
 
-func (c *Controller) compileFileInfosFor(change *drive.Change) (fileName string, fileIsFolder bool, fileTrashed bool, skip bool, err error) {
+func (c *Controller) compileFileInfosFor(change *drive.Change) (fileInfo driveFileBasicInfo, fileTrashed bool, skip bool, err error) {
 	// If file metadata is attached to change event, use them directly
 	if change.File != nil {
-		fileName = change.File.Name
-		fileIsFolder = change.File.MimeType == folderMimeType
+		fileInfo = newDriveFileBasicInfo(change.File)
 		fileTrashed = change.File.Trashed
 		return
 	}
 	// Else, search it within our local index
-	var (
-		fi    driveFileBasicInfo
-		found bool
-	)
-	if found, err = c.index.Get(change.FileId, &fi); err != nil {
+	var found bool
+	if found, err = c.index.Get(change.FileId, &fileInfo); err != nil {
 		err = fmt.Errorf("failed to get fileID '%s' infos from local index: %w", change.FileId, err)
 		return
 	}
@@ -232,7 +337,5 @@ func (c *Controller) compileFileInfosFor(change *drive.Change) (fileName string,
 		}
 		return
 	}
-	fileName = fi.Name
-	fileIsFolder = fi.Folder
 	return
 }
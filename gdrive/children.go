@@ -0,0 +1,60 @@
+package gdrive
+
+import (
+	"fmt"
+)
+
+const (
+	// index keys referencing the children of a folder, one per child: prefix + folderID + ':' + childID
+	indexChildrenPrefix = "childOf:"
+)
+
+func childrenPrefix(folderID string) string {
+	return indexChildrenPrefix + folderID + ":"
+}
+
+// updateChildren moves the references of fileID as a child from its previous parents to its current ones
+func (c *Controller) updateChildren(fileID string, previousParents, parents []string) (err error) {
+	for _, previousParent := range previousParents {
+		if containsID(parents, previousParent) {
+			continue
+		}
+		if err = c.index.Delete(childrenPrefix(previousParent) + fileID); err != nil {
+			err = fmt.Errorf("failed to remove fileID '%s' from the children of folderID '%s': %w", fileID, previousParent, err)
+			return
+		}
+	}
+	for _, parent := range parents {
+		if containsID(previousParents, parent) {
+			continue
+		}
+		if err = c.index.Set(childrenPrefix(parent)+fileID, true); err != nil {
+			err = fmt.Errorf("failed to add fileID '%s' to the children of folderID '%s': %w", fileID, parent, err)
+			return
+		}
+	}
+	return
+}
+
+func (c *Controller) getChildren(folderID string) (children []string, err error) {
+	prefix := childrenPrefix(folderID)
+	keys, err := c.index.KeysWithPrefix(prefix)
+	if err != nil {
+		err = fmt.Errorf("failed to get the children of folderID '%s' from local index: %w", folderID, err)
+		return
+	}
+	children = make([]string, len(keys))
+	for index, key := range keys {
+		children[index] = key[len(prefix):]
+	}
+	return
+}
+
+func containsID(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
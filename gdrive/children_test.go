@@ -0,0 +1,99 @@
+package gdrive
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hekmon/rcgdip/drivechange"
+)
+
+func newTestChildrenController(t *testing.T) *Controller {
+	c := newTestIndexController(t, "", "", nil)
+	for _, file := range []struct {
+		id   string
+		info driveFileBasicInfo
+	}{
+		{id: "root", info: driveFileBasicInfo{Name: "My Drive", Folder: true}},
+		{id: "movies", info: driveFileBasicInfo{Name: "Movies", Folder: true, Parents: []string{"root"}}},
+		{id: "movie", info: driveFileBasicInfo{Name: "Movie (2022)", Folder: true, Parents: []string{"movies"}}},
+		{id: "a", info: driveFileBasicInfo{Name: "a.mkv", Parents: []string{"movie"}}},
+		{id: "b", info: driveFileBasicInfo{Name: "b.mkv", Parents: []string{"movie", "kids"}}},
+		{id: "c", info: driveFileBasicInfo{Name: "c.mkv", Parents: []string{"movies"}}},
+		{id: "kids", info: driveFileBasicInfo{Name: "Kids", Folder: true, Parents: []string{"root"}}},
+		{id: "d", info: driveFileBasicInfo{Name: "d.mkv", Parents: []string{"kids"}}},
+		{id: "shortcut", info: driveFileBasicInfo{Name: "Kids", Folder: true, ShortcutTarget: "kids", Parents: []string{"movies"}}},
+	} {
+		if err := c.indexFile(file.id, file.info); err != nil {
+			t.Fatalf("failed to index fileID '%s': %s", file.id, err)
+		}
+	}
+	return c
+}
+
+func TestChildrenIndex(t *testing.T) {
+	c := newTestChildrenController(t)
+	children := func(folderID string) []string {
+		children, err := c.getChildren(folderID)
+		if err != nil {
+			t.Fatalf("failed to get the children of '%s': %s", folderID, err)
+		}
+		sort.Strings(children)
+		return children
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(children("movie"), expected) {
+		t.Errorf("children of 'movie': expected %v, got %v", expected, children("movie"))
+	}
+	// Moving a file updates its references
+	if err := c.indexFile("b", driveFileBasicInfo{Name: "b.mkv", Parents: []string{"kids"}}); err != nil {
+		t.Fatalf("failed to move 'b': %s", err)
+	}
+	if expected := []string{"a"}; !reflect.DeepEqual(children("movie"), expected) {
+		t.Errorf("children of 'movie' after the move: expected %v, got %v", expected, children("movie"))
+	}
+	if expected := []string{"b", "d"}; !reflect.DeepEqual(children("kids"), expected) {
+		t.Errorf("children of 'kids' after the move: expected %v, got %v", expected, children("kids"))
+	}
+	// Unindexing a file removes its references
+	if err := c.unindexFile("a"); err != nil {
+		t.Fatalf("failed to unindex 'a': %s", err)
+	}
+	if len(children("movie")) != 0 {
+		t.Errorf("expected 'movie' to have no children left, got %v", children("movie"))
+	}
+}
+
+func TestCountRemovedFoldersFiles(t *testing.T) {
+	testCases := []struct {
+		name    string
+		enabled bool
+		files   []int
+	}{
+		{name: "brake enabled", enabled: true, files: []int{5, 2, 0}},
+		{name: "brake disabled", files: []int{0, 0, 0}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestChildrenController(t)
+			c.countRemoved = tc.enabled
+			changedFiles := []drivechange.File{
+				// movies shows a, b, c and, thru the shortcut, b again and d: each location is a Plex item
+				{ID: "movies", Folder: true, Deleted: true, Moved: true},
+				// the shortcut shows the content of its target
+				{ID: "shortcut", Folder: true, Deleted: true},
+				// not removed
+				{ID: "kids", Folder: true},
+			}
+			if err := c.countRemovedFoldersFiles(changedFiles); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			files := make([]int, len(changedFiles))
+			for index, changedFile := range changedFiles {
+				files[index] = changedFile.Files
+			}
+			if !reflect.DeepEqual(files, tc.files) {
+				t.Errorf("expected %v, got %v", tc.files, files)
+			}
+		})
+	}
+}
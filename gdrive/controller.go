@@ -2,7 +2,6 @@ package gdrive
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -18,11 +17,18 @@ import (
 type Config struct {
 	RClone       rcsnooper.Config
 	PollInterval time.Duration
+	PushURL      string // optional, enables changes push notifications
+	PushListen   string // local address receiving push notifications
+	CountRemoved bool   // count the files within the deleted or moved away folders (mass deletion brake)
 	Logger       *hllogger.Logger
 	StateBackend Storage
 	IndexBackend Storage
 	KillSwitch   func()
-	Output       chan<- []drivechange.File
+	Output       Queue
+}
+
+type Queue interface {
+	Enqueue([]drivechange.File) error
 }
 
 type Storage interface {
@@ -31,6 +37,7 @@ type Storage interface {
 	Get(string, interface{}) (bool, error)
 	Has(string) bool
 	Keys() []string
+	KeysWithPrefix(string) ([]string, error)
 	NbKeys() int
 	Set(string, interface{}) error
 	Sync() error
@@ -45,12 +52,26 @@ type Controller struct {
 	rc *rcsnooper.Controller
 	// Google Drive API client
 	driveClient *drive.Service
-	limiter     *rate.Limiter
+	// Google Docs export (as rclone does)
+	exportExtensions []string
+	exportFormats    map[string][]string
+	limiter          *rate.Limiter
+	lastThrottle     time.Time
 	// Storage
-	state Storage
-	index Storage
+	state   Storage
+	index   Storage
+	journal *indexJournal // wraps index
+	// Changes processing
+	countRemoved bool
 	// Watcher info
-	output chan<- []drivechange.File
+	output   Queue
+	lastPass time.Time // last successful changes check
+	// Push notifications
+	pushURL        string
+	pushToken      string
+	pushNotif      chan struct{}
+	pushChannel    *drive.Channel
+	pushExpiration time.Time
 	// Workers control plane
 	workers  sync.WaitGroup
 	fullStop chan struct{}
@@ -69,31 +90,42 @@ func New(ctx context.Context, conf Config) (c *Controller, err error) {
 		return
 	}
 	conf.Logger.Infof("[Drive] %s", rc.Summary())
-	// Prevent futur indexing to fail for now
-	if rc.Drive.Options.Scope == "drive.file" {
-		err = errors.New("scope 'drive.file' is not supported yet (indexing will fail), please refer to project readme")
-		return
+	if rc.Drive.Options.Scope == restrictedScope {
+		conf.Logger.Infof("[Drive] scope '%s' detected: the index will be built by walking the tree from the root folder", restrictedScope)
 	}
 	// Then we initialize ourself
 	c = &Controller{
-		ctx:        ctx,
-		logger:     conf.Logger,
-		killSwitch: conf.KillSwitch,
-		rc:         rc,
-		limiter:    rate.NewLimiter(rate.Every(time.Minute/requestPerMin), requestPerMin/2),
-		state:      conf.StateBackend,
-		index:      conf.IndexBackend,
-		output:     conf.Output,
+		ctx:          ctx,
+		logger:       conf.Logger,
+		killSwitch:   conf.KillSwitch,
+		rc:           rc,
+		limiter:      rate.NewLimiter(rate.Every(time.Minute/requestPerMin), requestPerMin/2),
+		state:        conf.StateBackend,
+		journal:      newIndexJournal(conf.IndexBackend, conf.StateBackend),
+		output:       conf.Output,
+		pushURL:      conf.PushURL,
+		countRemoved: conf.CountRemoved,
 	}
+	c.index = c.journal
 	if err = c.initDriveClient(); err != nil {
 		err = fmt.Errorf("unable to initialize Drive API client: %w", err)
 		return
 	}
+	if err = c.initDocsExport(); err != nil {
+		err = fmt.Errorf("unable to initialize Google Docs export: %w", err)
+		return
+	}
 	// Some weird case
 	if c.rc.Drive.Options.RootFolderID == "root" {
 		c.rc.Drive.Options.RootFolderID = ""
 	}
 	// Workers
+	if c.pushURL != "" {
+		if err = c.startPushListener(conf.PushListen); err != nil {
+			err = fmt.Errorf("unable to start the push notifications listener: %w", err)
+			return
+		}
+	}
 	c.fullStop = make(chan struct{})
 	go c.stopper()
 	c.workers.Add(1)
@@ -0,0 +1,141 @@
+package gdrive
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	documentMimeTypePrefix  = "application/vnd.google-apps."
+	shortcutMimeType        = "application/vnd.google-apps.shortcut"
+	defaultExportExtensions = "docx,xlsx,pptx,svg" // same as the rclone drive backend
+)
+
+var (
+	// same as the rclone drive backend
+	mimeTypeCustomTransform = map[string]string{
+		"application/vnd.google-apps.script+json": "application/json",
+	}
+)
+
+// initDocsExport prepares what is needed to expose the Google Docs the same way the rclone drive backend does
+func (c *Controller) initDocsExport() (err error) {
+	if c.rc.Drive.Options.SkipGdocs {
+		return
+	}
+	// Preferred extensions (the legacy "formats" option replaces "export_formats")
+	exportExtensions := c.rc.Drive.Options.ExportExtensions
+	if c.rc.Drive.Options.Extensions != "" {
+		exportExtensions = c.rc.Drive.Options.Extensions
+	}
+	if c.exportExtensions, err = parseExportExtensions(exportExtensions, defaultExportExtensions); err != nil {
+		err = fmt.Errorf("failed to parse the export formats: %w", err)
+		return
+	}
+	// Formats each document type can be exported to
+	if c.exportFormats, err = c.getDriveExportFormats(); err != nil {
+		err = fmt.Errorf("failed to get the export formats of Google Docs: %w", err)
+		return
+	}
+	c.logger.Debugf("[Drive] Google Docs will be exposed with the first available extension within: %s", strings.Join(c.exportExtensions, ", "))
+	return
+}
+
+// documentType returns the mime type of Google Docs, empty for regular files, folders and shortcuts
+func documentType(mimeType string) string {
+	if !strings.HasPrefix(mimeType, documentMimeTypePrefix) || mimeType == folderMimeType || mimeType == shortcutMimeType {
+		return ""
+	}
+	return mimeType
+}
+
+// documentExtension returns the extension rclone adds to a Google Doc name, visible is false if rclone does not expose it
+func (c *Controller) documentExtension(docType string) (extension string, visible bool) {
+	if c.rc.Drive.Options.SkipGdocs {
+		return
+	}
+	if exportMimeTypes, isDocument := c.exportFormats[docType]; isDocument {
+		for _, extension = range c.exportExtensions {
+			extensionMimeType := mime.TypeByExtension(extension)
+			if isLinkMimeType(extensionMimeType) {
+				return extension, true
+			}
+			for _, exportMimeType := range exportMimeTypes {
+				if exportMimeType == extensionMimeType || extensionMimeType == mimeTypeCustomTransform[exportMimeType] {
+					return extension, true
+				}
+			}
+		}
+	}
+	// Link exports apply to every document
+	for _, extension = range c.exportExtensions {
+		if isLinkMimeType(mime.TypeByExtension(extension)) {
+			return extension, true
+		}
+	}
+	return "", false
+}
+
+func (c *Controller) hiddenDocument(docType string) bool {
+	if docType == "" {
+		return false
+	}
+	_, visible := c.documentExtension(docType)
+	return !visible
+}
+
+// newDriveFileBasicInfo resolves shortcuts as rclone does: they keep their name and parents but take the type of their target
+func newDriveFileBasicInfo(file *drive.File) driveFileBasicInfo {
+	fileInfo := driveFileBasicInfo{
+		Name:    file.Name,
+		Parents: file.Parents,
+	}
+	mimeType := file.MimeType
+	if mimeType == shortcutMimeType && file.ShortcutDetails != nil {
+		fileInfo.ShortcutTarget = file.ShortcutDetails.TargetId
+		mimeType = file.ShortcutDetails.TargetMimeType
+	}
+	fileInfo.Folder = mimeType == folderMimeType
+	fileInfo.DocType = documentType(mimeType)
+	return fileInfo
+}
+
+func parseExportExtensions(extensionsLists ...string) (extensions []string, err error) {
+	known := make(map[string]bool)
+	for _, extensionsList := range extensionsLists {
+		for _, extension := range strings.Split(extensionsList, ",") {
+			if extension = strings.ToLower(strings.TrimSpace(extension)); extension == "" {
+				continue
+			}
+			if extension[0] != '.' {
+				extension = "." + extension
+			}
+			if mime.TypeByExtension(extension) == "" {
+				err = fmt.Errorf("couldn't find MIME type for extension '%s'", extension)
+				return
+			}
+			if !known[extension] {
+				known[extension] = true
+				extensions = append(extensions, extension)
+			}
+		}
+	}
+	return
+}
+
+func isLinkMimeType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "application/x-link-")
+}
+
+// fixMimeType adds a charset to text mime types, as mime.TypeByExtension() does
+func fixMimeType(mimeType string) string {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil || !strings.HasPrefix(mediaType, "text/") || params["charset"] != "" {
+		return mimeType
+	}
+	params["charset"] = "utf-8"
+	return mime.FormatMediaType(mediaType, params)
+}
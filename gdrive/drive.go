@@ -1,12 +1,17 @@
 package gdrive
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/hekmon/rcgdip/gdrive/rcsnooper"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
@@ -15,6 +20,7 @@ import (
 const (
 	requestPerMin     = 300 / 2 // Let's share with rclone https://developers.google.com/docs/api/limits
 	scopePrefix       = "https://www.googleapis.com/auth/"
+	restrictedScope   = "drive.file"
 	folderMimeType    = "application/vnd.google-apps.folder"
 	maxFilesPerPage   = 1000
 	maxChangesPerPage = 1000
@@ -22,18 +28,49 @@ const (
 )
 
 func (c *Controller) initDriveClient() (err error) {
+	// Init the HTTP client handling authentication
+	client, err := newDriveHTTPClient(c.ctx, c.rc.Drive)
+	if err != nil {
+		return
+	}
+	// Init Drive API client on top of that
+	c.driveClient, err = drive.NewService(c.ctx, option.WithHTTPClient(client))
+	return
+}
+
+func newDriveHTTPClient(ctx context.Context, backend rcsnooper.DriveBackend) (client *http.Client, err error) {
+	// Service account takes precedence over oauth2 (same as rclone)
+	if backend.ServiceAccount != nil {
+		var jwtConf *jwt.Config
+		if jwtConf, err = newServiceAccountConfig(backend); err != nil {
+			return
+		}
+		// Init the HTTP JWT enabled client
+		client = jwtConf.Client(ctx)
+		return
+	}
 	// Prepare the OAuth2 configuration
 	oauthConf := &oauth2.Config{
-		Scopes:       []string{scopePrefix + c.rc.Drive.Options.Scope},
+		Scopes:       []string{scopePrefix + backend.Options.Scope},
 		Endpoint:     google.Endpoint,
-		ClientID:     c.rc.Drive.ClientID,
-		ClientSecret: c.rc.Drive.ClientSecret,
+		ClientID:     backend.ClientID,
+		ClientSecret: backend.ClientSecret,
 		// RedirectURL:  oauthutil.TitleBarRedirectURL,
 	}
 	// Init the HTTP OAuth2 enabled client
-	client := oauthConf.Client(c.ctx, c.rc.Drive.Token)
-	// Init Drive API client on top of that
-	c.driveClient, err = drive.NewService(c.ctx, option.WithHTTPClient(client))
+	client = oauthConf.Client(ctx, backend.Token)
+	return
+}
+
+func newServiceAccountConfig(backend rcsnooper.DriveBackend) (jwtConf *jwt.Config, err error) {
+	// Prepare the JWT configuration from the service account credentials
+	if jwtConf, err = google.JWTConfigFromJSON(backend.ServiceAccount, scopePrefix+backend.Options.Scope); err != nil {
+		err = fmt.Errorf("failed to process service account credentials: %w", err)
+		return
+	}
+	if backend.Options.Impersonate != "" {
+		jwtConf.Subject = backend.Options.Impersonate
+	}
 	return
 }
 
@@ -43,19 +80,22 @@ func (c *Controller) getDriveChangesStartPage() (changesStartToken string, err e
 	if c.rc.Drive.Options.TeamDriveID != "" {
 		changesReq.SupportsAllDrives(true).DriveId(c.rc.Drive.Options.TeamDriveID)
 	}
-	changesStart, err := changesReq.Do()
-	if err != nil {
+	var changesStart *drive.StartPageToken
+	if err = c.apiCall("changes start page token request", func() (err error) {
+		changesStart, err = changesReq.Do()
+		return
+	}); err != nil {
 		return
 	}
 	changesStartToken = changesStart.StartPageToken
 	return
 }
 
-func (c *Controller) getDriveListing(pageToken string) (files []*drive.File, nextPageToken string, err error) {
+func (c *Controller) getDriveListing(query, pageToken string) (files []*drive.File, nextPageToken string, err error) {
 	c.logger.Debug("[Drive] getting a new page of files...")
 	// Build Request
-	listReq := c.driveClient.Files.List()
-	listReq.Spaces("drive").Q("trashed=false")
+	listReq := c.driveClient.Files.List().Context(c.ctx)
+	listReq.Spaces("drive").Q(query)
 	if c.rc.Drive.Options.TeamDriveID != "" {
 		listReq.Corpora("drive").SupportsAllDrives(true).IncludeItemsFromAllDrives(true).DriveId(c.rc.Drive.Options.TeamDriveID)
 	} else {
@@ -70,16 +110,16 @@ func (c *Controller) getDriveListing(pageToken string) (files []*drive.File, nex
 	} else {
 		listReq.PageSize(maxFilesPerPage)
 		listReq.Fields(googleapi.Field("nextPageToken"), googleapi.Field("files/id"), googleapi.Field("files/name"),
-			googleapi.Field("files/mimeType"), googleapi.Field("files/parents"))
+			googleapi.Field("files/mimeType"), googleapi.Field("files/parents"), googleapi.Field("files/shortcutDetails/targetId"),
+			googleapi.Field("files/shortcutDetails/targetMimeType"))
 	}
 	// Execute Request
-	if err = c.limiter.Wait(c.ctx); err != nil {
-		err = fmt.Errorf("can not execute API request, waiting for the limiter failed: %w", err)
-		return
-	}
+	var filesList *drive.FileList
 	start := time.Now()
-	filesList, err := listReq.Do()
-	if err != nil {
+	if err = c.apiCall("files list request", func() (err error) {
+		filesList, err = listReq.Do()
+		return
+	}); err != nil {
 		err = fmt.Errorf("failed to execute the API query for files list: %w", err)
 		return
 	}
@@ -91,10 +131,10 @@ func (c *Controller) getDriveListing(pageToken string) (files []*drive.File, nex
 	return
 }
 
-func (c *Controller) getDriveChanges(nextPageToken string) (changes []*drive.Change, nextStartPage string, err error) {
+func (c *Controller) getDriveChanges(pageToken string) (changes []*drive.Change, nextPageToken, newStartPage string, err error) {
 	c.logger.Debug("[Drive] getting a new page of changes...")
 	// Build Request
-	changesReq := c.driveClient.Changes.List(nextPageToken).Context(c.ctx)
+	changesReq := c.driveClient.Changes.List(pageToken).Context(c.ctx)
 	changesReq.IncludeRemoved(true)
 	if c.rc.Drive.Options.TeamDriveID != "" {
 		changesReq.SupportsAllDrives(true).IncludeItemsFromAllDrives(true).DriveId(c.rc.Drive.Options.TeamDriveID)
@@ -108,31 +148,31 @@ func (c *Controller) getDriveChanges(nextPageToken string) (changes []*drive.Cha
 			googleapi.Field("changes"), googleapi.Field("changes/fileId"), googleapi.Field("changes/removed"),
 			googleapi.Field("changes/time"), googleapi.Field("changes/changeType"), googleapi.Field("changes/file"),
 			googleapi.Field("changes/file/name"), googleapi.Field("changes/file/mimeType"), googleapi.Field("changes/file/trashed"),
-			googleapi.Field("changes/file/parents"), googleapi.Field("changes/file/createdTime"))
+			googleapi.Field("changes/file/parents"), googleapi.Field("changes/file/createdTime"),
+			googleapi.Field("changes/file/shortcutDetails/targetId"), googleapi.Field("changes/file/shortcutDetails/targetMimeType"))
 	}
 	// Execute Request
-	if err = c.limiter.Wait(c.ctx); err != nil {
-		err = fmt.Errorf("can not execute API request, waiting for the limiter failed: %w", err)
-		return
-	}
+	var changeList *drive.ChangeList
 	start := time.Now()
-	changeList, err := changesReq.Do()
-	if err != nil {
+	if err = c.apiCall("changes list request", func() (err error) {
+		changeList, err = changesReq.Do()
+		return
+	}); err != nil {
 		err = fmt.Errorf("failed to execute the API query for changes list: %w", err)
 		return
 	}
 	c.logger.Debugf("[Drive] changes page obtained in %v", time.Since(start))
 	// Extract changes from answer
 	changes = changeList.Changes
+	if c.logger.IsDebugShown() {
+		for index, change := range changes {
+			c.logger.Debugf("[Drive] raw change #%d: %+v", index+1, *change)
+		}
+	}
 	// Is there any pages left ?
 	if changeList.NextPageToken != "" {
 		c.logger.Debugf("[Drive] another page of changes is available at %s", changeList.NextPageToken)
-		var nextPagesChanges []*drive.Change
-		if nextPagesChanges, nextStartPage, err = c.getDriveChanges(changeList.NextPageToken); err != nil {
-			err = fmt.Errorf("failed to get change list next page: %w", err)
-			return
-		}
-		changes = append(changes, nextPagesChanges...)
+		nextPageToken = changeList.NextPageToken
 		return
 	}
 	// We are the last page of results, recover token for next run
@@ -141,13 +181,7 @@ func (c *Controller) getDriveChanges(nextPageToken string) (changes []*drive.Cha
 		return
 	}
 	c.logger.Debugf("[Drive] no more changes pages, recovering the marker for next run: %s", changeList.NewStartPageToken)
-	nextStartPage = changeList.NewStartPageToken
-	// Done
-	if c.logger.IsDebugShown() {
-		for index, change := range changes {
-			c.logger.Debugf("[Drive] raw change #%d: %+v", index+1, *change)
-		}
-	}
+	newStartPage = changeList.NewStartPageToken
 	return
 }
 
@@ -164,28 +198,87 @@ func (c *Controller) getDriveFileInfoWithID(fileID string) (recoveredID string,
 	c.logger.Debugf("[Drive] requesting information about fileID '%s'...", fileID)
 	// Build request
 	fileRequest := c.driveClient.Files.Get(fileID).Context(c.ctx)
-	fileRequest.Fields(googleapi.Field("id"), googleapi.Field("name"), googleapi.Field("mimeType"), googleapi.Field("parents"))
+	fileRequest.Fields(googleapi.Field("id"), googleapi.Field("name"), googleapi.Field("mimeType"), googleapi.Field("parents"),
+		googleapi.Field("shortcutDetails/targetId"), googleapi.Field("shortcutDetails/targetMimeType"))
 	if c.rc.Drive.Options.TeamDriveID != "" {
 		fileRequest.SupportsAllDrives(true)
 	}
 	// Execute request
-	if err = c.limiter.Wait(c.ctx); err != nil {
-		err = fmt.Errorf("can not execute API request, waiting for the limiter failed: %w", err)
-		return
-	}
+	var fii *drive.File
 	start := time.Now()
-	fii, err := fileRequest.Do()
-	if err != nil {
+	if err = c.apiCall("file info request", func() (err error) {
+		fii, err = fileRequest.Do()
+		return
+	}); err != nil {
 		err = fmt.Errorf("failed to execute file info get API query: %w", err)
 		return
 	}
 	c.logger.Debugf("[Drive] information about fileID '%s' recovered in %v", fileID, time.Since(start))
 	// Extract data
 	recoveredID = fii.Id
-	infos = &driveFileBasicInfo{
-		Name:    fii.Name,
-		Folder:  fii.MimeType == folderMimeType,
-		Parents: fii.Parents,
+	fileInfo := newDriveFileBasicInfo(fii)
+	infos = &fileInfo
+	return
+}
+
+func (c *Controller) getDriveExportFormats() (exportFormats map[string][]string, err error) {
+	c.logger.Debug("[Drive] requesting the export formats...")
+	// Build request
+	aboutRequest := c.driveClient.About.Get().Context(c.ctx)
+	aboutRequest.Fields(googleapi.Field("exportFormats"))
+	// Execute request
+	var about *drive.About
+	if err = c.apiCall("about request", func() (err error) {
+		about, err = aboutRequest.Do()
+		return
+	}); err != nil {
+		err = fmt.Errorf("failed to execute about get API query: %w", err)
+		return
+	}
+	// Extract data (with the same mime types as mime.TypeByExtension())
+	exportFormats = make(map[string][]string, len(about.ExportFormats))
+	for docType, mimeTypes := range about.ExportFormats {
+		fixedMimeTypes := make([]string, len(mimeTypes))
+		for index, mimeType := range mimeTypes {
+			fixedMimeTypes[index] = fixMimeType(mimeType)
+		}
+		exportFormats[fixMimeType(docType)] = fixedMimeTypes
+	}
+	return
+}
+
+func (c *Controller) getDriveChangesWatch(pageToken string, channel *drive.Channel) (registered *drive.Channel, err error) {
+	c.logger.Debugf("[Drive] registering push notifications channel '%s'...", channel.Id)
+	// Build request
+	watchReq := c.driveClient.Changes.Watch(pageToken, channel).Context(c.ctx)
+	watchReq.IncludeRemoved(true)
+	if c.rc.Drive.Options.TeamDriveID != "" {
+		watchReq.SupportsAllDrives(true).IncludeItemsFromAllDrives(true).DriveId(c.rc.Drive.Options.TeamDriveID)
+	}
+	// Execute request
+	start := time.Now()
+	if err = c.apiCall("changes watch request", func() (err error) {
+		registered, err = watchReq.Do()
+		return
+	}); err != nil {
+		err = fmt.Errorf("failed to execute the API query for changes watch: %w", err)
+		return
+	}
+	c.logger.Debugf("[Drive] push notifications channel '%s' registered in %v", registered.Id, time.Since(start))
+	return
+}
+
+func (c *Controller) stopDriveChannel(ctx context.Context, channel *drive.Channel) (err error) {
+	c.logger.Debugf("[Drive] stopping push notifications channel '%s'...", channel.Id)
+	// Execute request (do not use the limiter as we might be stopping)
+	start := time.Now()
+	if err = c.driveClient.Channels.Stop(&drive.Channel{
+		Id:         channel.Id,
+		ResourceId: channel.ResourceId,
+	}).Context(ctx).Do(); err != nil {
+		err = fmt.Errorf("failed to execute the API query for channel stop: %w", err)
+		return
 	}
+	c.logger.Debugf("[Drive] push notifications channel '%s' stopped in %v", channel.Id, time.Since(start))
 	return
 }
@@ -0,0 +1,247 @@
+package gdrive
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hekmon/rcgdip/gdrive/rcsnooper"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	fakeServiceAccountEmail = "rcgdip@project.iam.gserviceaccount.com"
+	fakeServiceAccountToken = "service-account-token"
+	fakeOAuth2Token         = "oauth2-token"
+)
+
+type jwtClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Scope    string `json:"scope"`
+	Audience string `json:"aud"`
+}
+
+// fakeTokenEndpoint mimics the Google OAuth2 token endpoint for the JWT bearer grant and records the received claims
+type fakeTokenEndpoint struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	claims []jwtClaims
+}
+
+func newFakeTokenEndpoint(t *testing.T) (fte *fakeTokenEndpoint) {
+	fte = new(fakeTokenEndpoint)
+	fte.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("token endpoint: failed to parse form: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if grantType := r.PostForm.Get("grant_type"); grantType != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("token endpoint: unexpected grant type: %s", grantType)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Decode the JWT claims (signature is not checked)
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("token endpoint: invalid assertion: %d part(s)", len(parts))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Errorf("token endpoint: failed to decode the assertion payload: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var claims jwtClaims
+		if err = json.Unmarshal(payload, &claims); err != nil {
+			t.Errorf("token endpoint: failed to unmarshal the assertion claims: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fte.mutex.Lock()
+		fte.claims = append(fte.claims, claims)
+		fte.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fakeServiceAccountToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(fte.Close)
+	return
+}
+
+func (fte *fakeTokenEndpoint) receivedClaims() []jwtClaims {
+	fte.mutex.Lock()
+	defer fte.mutex.Unlock()
+	return append([]jwtClaims(nil), fte.claims...)
+}
+
+func (fte *fakeTokenEndpoint) credentials(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the service account key: %s", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal the service account key: %s", err)
+	}
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project",
+		"private_key_id": "keyid",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		"client_email":   fakeServiceAccountEmail,
+		"client_id":      "123456789",
+		"token_uri":      fte.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal the service account credentials: %s", err)
+	}
+	return credentials
+}
+
+// authorizationOf returns the Authorization header the client sends
+func authorizationOf(t *testing.T, client *http.Client) (authorization string) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer api.Close()
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatalf("failed to query the fake API: %s", err)
+	}
+	resp.Body.Close()
+	return
+}
+
+func TestNewServiceAccountConfig(t *testing.T) {
+	fte := newFakeTokenEndpoint(t)
+	backend := rcsnooper.DriveBackend{
+		ServiceAccount: fte.credentials(t),
+	}
+	backend.Options.Scope = "drive"
+	// Without impersonation
+	jwtConf, err := newServiceAccountConfig(backend)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if jwtConf.Email != fakeServiceAccountEmail {
+		t.Errorf("email: expected '%s', got '%s'", fakeServiceAccountEmail, jwtConf.Email)
+	}
+	if jwtConf.TokenURL != fte.URL {
+		t.Errorf("token URL: expected '%s', got '%s'", fte.URL, jwtConf.TokenURL)
+	}
+	if len(jwtConf.Scopes) != 1 || jwtConf.Scopes[0] != scopePrefix+"drive" {
+		t.Errorf("scopes: expected [%s], got %v", scopePrefix+"drive", jwtConf.Scopes)
+	}
+	if jwtConf.Subject != "" {
+		t.Errorf("subject: expected none, got '%s'", jwtConf.Subject)
+	}
+	// With impersonation
+	backend.Options.Impersonate = "user@example.com"
+	if jwtConf, err = newServiceAccountConfig(backend); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if jwtConf.Subject != "user@example.com" {
+		t.Errorf("subject: expected 'user@example.com', got '%s'", jwtConf.Subject)
+	}
+	// Invalid credentials
+	backend.ServiceAccount = []byte("{")
+	if _, err = newServiceAccountConfig(backend); err == nil {
+		t.Error("expected an error with invalid credentials")
+	}
+}
+
+func TestNewDriveHTTPClientServiceAccount(t *testing.T) {
+	testCases := []struct {
+		name        string
+		impersonate string
+		scope       string
+	}{
+		{name: "plain", scope: "drive"},
+		{name: "impersonation", impersonate: "user@example.com", scope: "drive.readonly"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fte := newFakeTokenEndpoint(t)
+			backend := rcsnooper.DriveBackend{
+				ServiceAccount: fte.credentials(t),
+			}
+			backend.Options.Scope = tc.scope
+			backend.Options.Impersonate = tc.impersonate
+			client, err := newDriveHTTPClient(context.Background(), backend)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if authorization := authorizationOf(t, client); authorization != "Bearer "+fakeServiceAccountToken {
+				t.Errorf("authorization: expected 'Bearer %s', got '%s'", fakeServiceAccountToken, authorization)
+			}
+			claims := fte.receivedClaims()
+			if len(claims) != 1 {
+				t.Fatalf("expected 1 token request, got %d", len(claims))
+			}
+			if claims[0].Issuer != fakeServiceAccountEmail {
+				t.Errorf("issuer: expected '%s', got '%s'", fakeServiceAccountEmail, claims[0].Issuer)
+			}
+			if claims[0].Subject != tc.impersonate {
+				t.Errorf("subject: expected '%s', got '%s'", tc.impersonate, claims[0].Subject)
+			}
+			if claims[0].Scope != scopePrefix+tc.scope {
+				t.Errorf("scope: expected '%s', got '%s'", scopePrefix+tc.scope, claims[0].Scope)
+			}
+			if claims[0].Audience != fte.URL {
+				t.Errorf("audience: expected '%s', got '%s'", fte.URL, claims[0].Audience)
+			}
+		})
+	}
+}
+
+func TestNewDriveHTTPClientPrecedence(t *testing.T) {
+	fte := newFakeTokenEndpoint(t)
+	token := &oauth2.Token{
+		AccessToken: fakeOAuth2Token,
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}
+	// OAuth2 only
+	backend := rcsnooper.DriveBackend{
+		ClientID:     "clientid",
+		ClientSecret: "clientsecret",
+		Token:        token,
+	}
+	backend.Options.Scope = "drive"
+	client, err := newDriveHTTPClient(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authorization := authorizationOf(t, client); authorization != "Bearer "+fakeOAuth2Token {
+		t.Errorf("oauth2 only: expected 'Bearer %s', got '%s'", fakeOAuth2Token, authorization)
+	}
+	// Service account along an oauth2 token: the service account wins
+	backend.ServiceAccount = fte.credentials(t)
+	if client, err = newDriveHTTPClient(context.Background(), backend); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authorization := authorizationOf(t, client); authorization != "Bearer "+fakeServiceAccountToken {
+		t.Errorf("service account and oauth2: expected 'Bearer %s', got '%s'", fakeServiceAccountToken, authorization)
+	}
+	if nbRequests := len(fte.receivedClaims()); nbRequests != 1 {
+		t.Errorf("expected 1 token request, got %d", nbRequests)
+	}
+}
@@ -0,0 +1,156 @@
+package gdrive
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hekmon/rcgdip/drivechange"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	maxFoldersWalkedForFiles = 20
+)
+
+// folderChangeMatters returns true if a created, moved or renamed folder contains files which need to be scanned
+func (c *Controller) folderChangeMatters(folderID string, unchanged bool) bool {
+	if unchanged {
+		c.logger.Debugf("[Drive] folderID '%s' has been neither moved nor renamed: skipping its change", folderID)
+		return false
+	}
+	// Shortcuts to a folder show the content of their target
+	walkFrom, err := c.resolveShortcut(folderID)
+	if err != nil {
+		c.logger.Warningf("[Drive] failed to resolve folderID '%s', considering it contains files: %s", folderID, err)
+		return true
+	}
+	hasFiles, err := c.folderHasFiles(walkFrom)
+	if err != nil {
+		c.logger.Warningf("[Drive] failed to check if folderID '%s' contains files, considering it does: %s", folderID, err)
+		return true
+	}
+	if !hasFiles {
+		c.logger.Debugf("[Drive] folderID '%s' does not contain any file (yet): skipping its change", folderID)
+	}
+	return hasFiles
+}
+
+// folderHasFiles walks the folder tree breadth first until it finds a file
+func (c *Controller) folderHasFiles(folderID string) (hasFiles bool, err error) {
+	var (
+		folder        string
+		pageFiles     []*drive.File
+		nextPageToken string
+		fileInfo      driveFileBasicInfo
+	)
+	toWalk := []string{folderID}
+	for walked := 0; len(toWalk) > 0; walked++ {
+		if walked == maxFoldersWalkedForFiles {
+			c.logger.Debugf("[Drive] folderID '%s' tree is too big to be fully walked (%d folders walked), considering it contains files",
+				folderID, walked)
+			return true, nil
+		}
+		folder, toWalk = toWalk[0], toWalk[1:]
+		nextPageToken = ""
+		for {
+			if pageFiles, nextPageToken, err = c.getDriveListing(fmt.Sprintf("'%s' in parents and trashed=false", folder),
+				nextPageToken); err != nil {
+				err = fmt.Errorf("failed to list the content of folderID '%s': %w", folder, err)
+				return
+			}
+			for _, file := range pageFiles {
+				if fileInfo = newDriveFileBasicInfo(file); c.hiddenFile(fileInfo) {
+					continue
+				}
+				if !fileInfo.Folder {
+					return true, nil
+				}
+				if fileInfo.ShortcutTarget != "" {
+					toWalk = append(toWalk, fileInfo.ShortcutTarget)
+				} else {
+					toWalk = append(toWalk, file.Id)
+				}
+			}
+			if nextPageToken == "" {
+				break
+			}
+		}
+	}
+	return
+}
+
+// countRemovedFoldersFiles sets the number of files the deleted or moved away folders contained, according to the index
+func (c *Controller) countRemovedFoldersFiles(changedFiles []drivechange.File) (err error) {
+	if !c.countRemoved {
+		return
+	}
+	start := time.Now()
+	// Shortcuts to a folder contain the files of their target
+	var (
+		walkFrom string
+		count    int
+		found    bool
+	)
+	counts := make(map[string]int)
+	for index, changedFile := range changedFiles {
+		if !changedFile.Folder || !changedFile.Deleted {
+			continue
+		}
+		if walkFrom, err = c.resolveShortcut(changedFile.ID); err != nil {
+			return
+		}
+		if count, found = counts[walkFrom]; !found {
+			if count, err = c.countIndexedFilesWithin(walkFrom); err != nil {
+				err = fmt.Errorf("failed to count the files within the deleted or moved away folderID '%s': %w", walkFrom, err)
+				return
+			}
+			counts[walkFrom] = count
+		}
+		changedFiles[index].Files = count
+	}
+	if len(counts) > 0 {
+		c.logger.Debugf("[Drive] files within %d deleted or moved away folder(s) counted in %v: %v", len(counts), time.Since(start), counts)
+	}
+	return
+}
+
+// countIndexedFilesWithin walks the folder tree thru the children index to count the indexed files within it
+func (c *Controller) countIndexedFilesWithin(folderID string) (files int, err error) {
+	var (
+		folder    string
+		children  []string
+		childInfo driveFileBasicInfo
+		found     bool
+	)
+	walked := map[string]bool{folderID: true}
+	toWalk := []string{folderID}
+	for len(toWalk) > 0 {
+		folder, toWalk = toWalk[0], toWalk[1:]
+		if children, err = c.getChildren(folder); err != nil {
+			return
+		}
+		for _, childID := range children {
+			childInfo = driveFileBasicInfo{}
+			if found, err = c.index.Get(childID, &childInfo); err != nil {
+				err = fmt.Errorf("failed to get fileID '%s' infos from local index: %w", childID, err)
+				return
+			}
+			if !found || c.hiddenFile(childInfo) {
+				continue
+			}
+			if !childInfo.Folder {
+				files++
+				continue
+			}
+			if childInfo.ShortcutTarget != "" {
+				childID = childInfo.ShortcutTarget
+			}
+			if !walked[childID] {
+				walked[childID] = true
+				toWalk = append(toWalk, childID)
+			}
+		}
+	}
+	return
+}
@@ -1,63 +1,120 @@
 package gdrive
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 )
 
 type driveFileBasicInfo struct {
-	Name    string   `json:"name"`
-	Folder  bool     `json:"isFolder"`
-	Parents []string `json:"parentsID"`
+	Name           string   `json:"name"`
+	Folder         bool     `json:"isFolder"`
+	DocType        string   `json:"docType,omitempty"`        // Google Docs only
+	ShortcutTarget string   `json:"shortcutTarget,omitempty"` // shortcuts only
+	Parents        []string `json:"parentsID"`
 }
 
-func (c *Controller) initialIndexBuild() (err error) {
-	c.logger.Notice("[Drive] building the initial index...")
+type indexProgress struct {
+	PageToken string `json:"pageToken"`
+	Folder    string `json:"folder,omitempty"` // tree walk only: folder currently being listed
+	Pages     int    `json:"pages"`
+	Files     int    `json:"files"`
+}
+
+func (c *Controller) indexBuild() (err error) {
+	// Recover the checkpoint
+	var (
+		progress indexProgress
+		found    bool
+	)
+	if found, err = c.state.Get(stateIndexProgressKey, &progress); err != nil {
+		err = fmt.Errorf("failed to get the index build progress from stored state: %w", err)
+		return
+	}
+	if !found {
+		err = errors.New("index build progress not found within stored state")
+		return
+	}
+	if progress.Pages > 0 {
+		c.logger.Noticef("[Drive] resuming the index build: %d list page(s) and %d files already indexed", progress.Pages, progress.Files)
+	}
+	// Index all the things
 	start := time.Now()
+	if c.rc.Drive.Options.Scope == restrictedScope {
+		err = c.initialIndexBuildWalk(progress)
+	} else {
+		err = c.initialIndexBuild(progress)
+	}
+	if err != nil {
+		return
+	}
+	if err = c.state.Set(stateIndexOK, true); err != nil {
+		err = fmt.Errorf("failed to save the index completion within our state: %w", err)
+		return
+	}
+	if err = c.state.Delete(stateIndexProgressKey); err != nil {
+		c.logger.Warningf("[Drive] failed to remove the index build progress from our state: %s", err)
+		err = nil
+	}
+	// Done
+	if c.logger.IsNoticeShown() {
+		// c.index.NbKeys() is filtered so a bit expensive
+		c.logger.Noticef("[Drive] index builded with %d entries in %v", c.index.NbKeys(), time.Since(start))
+	}
+	return
+}
+
+func (c *Controller) initialIndexBuild(progress indexProgress) (err error) {
+	c.logger.Notice("[Drive] building the initial index...")
 	// Get all the things, ahem files
 	var (
-		pageFiles        []*drive.File
-		nextPageToken    string
-		lastStatsUpdate  time.Time
-		pagesFetched     int
-		nbFilesRecovered int
+		pageFiles       []*drive.File
+		nextPageToken   string
+		lastStatsUpdate time.Time
 	)
 	for {
 		// Get page listing
-		if pageFiles, nextPageToken, err = c.getDriveListing(nextPageToken); err != nil {
+		if pageFiles, nextPageToken, err = c.getDriveListing("trashed=false", progress.PageToken); err != nil {
 			err = fmt.Errorf("recovering file listing from Google Drive failed: %w", err)
 			return
 		}
-		pagesFetched++
-		nbFilesRecovered += len(pageFiles)
 		// Build the index with the infos
-		for _, file := range pageFiles {
-			if err = c.index.Set(file.Id, driveFileBasicInfo{
-				Name:    file.Name,
-				Folder:  file.MimeType == folderMimeType,
-				Parents: file.Parents,
-			}); err != nil {
-				err = fmt.Errorf("failed to save file infos for fileID '%s' within the local index: %w", file.Id, err)
-				return
-			}
+		if err = c.indexFiles(pageFiles); err != nil {
+			return
 		}
 		// Listing over ?
 		if nextPageToken == "" {
 			break
 		}
+		// Checkpoint
+		progress.PageToken = nextPageToken
+		progress.Pages++
+		progress.Files += len(pageFiles)
+		if err = c.state.Set(stateIndexProgressKey, progress); err != nil {
+			err = fmt.Errorf("failed to save the index build progress within our state: %w", err)
+			return
+		}
 		// Put some stats out every minute as indexing can be quite long
 		if time.Since(lastStatsUpdate) >= time.Minute {
 			c.logger.Infof("[Drive] index building: so far %d list pages(s) has been recovered for a total of %d files",
-				pagesFetched, nbFilesRecovered)
+				progress.Pages, progress.Files)
 			lastStatsUpdate = time.Now()
 		}
 	}
-	// Done
-	if c.logger.IsNoticeShown() {
-		// c.index.NbKeys() is filtered so a bit expensive
-		c.logger.Noticef("[Drive] index builded with %d nodes in %v", c.index.NbKeys(), time.Since(start))
+	return
+}
+
+func (c *Controller) indexFiles(files []*drive.File) (err error) {
+	for _, file := range files {
+		if err = c.indexFile(file.Id, newDriveFileBasicInfo(file)); err != nil {
+			err = fmt.Errorf("failed to save file infos for fileID '%s' within the local index: %w", file.Id, err)
+			return
+		}
 	}
 	return
 }
@@ -77,15 +134,17 @@ func (c *Controller) fetchAndAddToIndexIfMissing(ids []string) (err error) {
 		}
 		// Get file infos
 		if fileInfo, err = c.getDriveFileInfo(fileID); err != nil {
+			// Within the restricted scope, parents outside of what the app can see are expected: they are out of the tree
+			if c.rc.Drive.Options.Scope == restrictedScope && isNotFound(err) {
+				c.logger.Debugf("[Drive] fileID '%s' is not accessible within the '%s' scope, skipping it", fileID, restrictedScope)
+				err = nil
+				continue
+			}
 			err = fmt.Errorf("failed to get file info for fileID '%s' from drive: %w", fileID, err)
 			return
 		}
 		// Save them
-		if err = c.index.Set(fileID, driveFileBasicInfo{
-			Name:    fileInfo.Name,
-			Folder:  fileInfo.Folder,
-			Parents: fileInfo.Parents,
-		}); err != nil {
+		if err = c.indexFile(fileID, *fileInfo); err != nil {
 			err = fmt.Errorf("failed to save file infos for fileID '%s' within the local index: %w", fileID, err)
 			return
 		}
@@ -100,3 +159,91 @@ func (c *Controller) fetchAndAddToIndexIfMissing(ids []string) (err error) {
 	// Every files has been searched and have their info now, time to return for real
 	return
 }
+
+func (c *Controller) initialIndexBuildWalk(progress indexProgress) (err error) {
+	c.logger.Notice("[Drive] building the initial index by walking the tree...")
+	// Recover the folders left to walk, each one is saved within the state to be able to resume
+	// (the folder being listed is resumed from the progress and stays within the state until its last page)
+	var (
+		toWalk   []string
+		folderID string
+	)
+	for _, key := range c.state.Keys() {
+		if !strings.HasPrefix(key, stateIndexWalkPrefix) {
+			continue
+		}
+		if folderID = key[len(stateIndexWalkPrefix):]; folderID != progress.Folder {
+			toWalk = append(toWalk, folderID)
+		}
+	}
+	// Walk the tree breadth first, one listing per folder: the only way to see every file within the restricted scope
+	var (
+		pageFiles       []*drive.File
+		nextPageToken   string
+		lastStatsUpdate time.Time
+	)
+	for progress.Folder != "" || len(toWalk) > 0 {
+		if progress.Folder == "" {
+			progress.Folder, toWalk = toWalk[0], toWalk[1:]
+			progress.PageToken = ""
+		}
+		for {
+			// Get page listing for this folder
+			if pageFiles, nextPageToken, err = c.getDriveListing(fmt.Sprintf("'%s' in parents and trashed=false", progress.Folder),
+				progress.PageToken); err != nil {
+				err = fmt.Errorf("recovering file listing of folderID '%s' from Google Drive failed: %w", progress.Folder, err)
+				return
+			}
+			// Build the index with the infos and stack up sub folders for walking
+			if err = c.indexFiles(pageFiles); err != nil {
+				return
+			}
+			for _, file := range pageFiles {
+				if file.MimeType != folderMimeType {
+					continue
+				}
+				if err = c.state.Set(stateIndexWalkPrefix+file.Id, true); err != nil {
+					err = fmt.Errorf("failed to save folderID '%s' as to be walked within our state: %w", file.Id, err)
+					return
+				}
+				toWalk = append(toWalk, file.Id)
+			}
+			// Checkpoint
+			progress.PageToken = nextPageToken
+			progress.Pages++
+			progress.Files += len(pageFiles)
+			if nextPageToken == "" {
+				// Folder listing over
+				if err = c.state.Delete(stateIndexWalkPrefix + progress.Folder); err != nil {
+					err = fmt.Errorf("failed to remove walked folderID '%s' from our state: %w", progress.Folder, err)
+					return
+				}
+				progress.Folder = ""
+			}
+			if err = c.state.Set(stateIndexProgressKey, progress); err != nil {
+				err = fmt.Errorf("failed to save the index build progress within our state: %w", err)
+				return
+			}
+			if progress.Folder == "" {
+				break
+			}
+		}
+		// Put some stats out every minute as indexing can be quite long
+		if time.Since(lastStatsUpdate) >= time.Minute {
+			c.logger.Infof("[Drive] index building: so far %d list pages(s) has been recovered for a total of %d files (%d folder(s) left to walk)",
+				progress.Pages, progress.Files, len(toWalk))
+			lastStatsUpdate = time.Now()
+		}
+	}
+	return
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func isBadRequest(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest
+}
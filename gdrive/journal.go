@@ -0,0 +1,129 @@
+package gdrive
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// indexJournal wraps the index to save, within the state, the previous value of each index entry modified while a changes page is processed.
+// If the page can not be fully handled (sent and checkpointed), it is processed again from the index as it was before it:
+// removed files can still be found and moved files still have their previous location.
+type indexJournal struct {
+	Storage         // the index
+	state   Storage // where the journal is saved
+	token   string  // page token being journaled, journaling is off when empty
+	saved   map[string]bool
+	entries int
+}
+
+type indexJournalEntry struct {
+	Token string          `json:"token"`
+	Key   string          `json:"key"`
+	Found bool            `json:"found"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func newIndexJournal(index, state Storage) *indexJournal {
+	return &indexJournal{
+		Storage: index,
+		state:   state,
+	}
+}
+
+func (ij *indexJournal) Set(key string, value interface{}) (err error) {
+	if err = ij.save(key); err != nil {
+		return
+	}
+	return ij.Storage.Set(key, value)
+}
+
+func (ij *indexJournal) Delete(key string) (err error) {
+	if err = ij.save(key); err != nil {
+		return
+	}
+	return ij.Storage.Delete(key)
+}
+
+// begin restores the index entries modified by a previous processing of the page, then starts journaling the ones modified by this one
+func (ij *indexJournal) begin(token string) (restored int, err error) {
+	var (
+		entry     indexJournalEntry
+		found     bool
+		nbEntries int
+	)
+	for ; ; nbEntries++ {
+		entry = indexJournalEntry{}
+		if found, err = ij.state.Get(journalEntryKey(nbEntries), &entry); err != nil {
+			err = fmt.Errorf("failed to get the index journal entry #%d: %w", nbEntries, err)
+			return
+		}
+		if !found {
+			break
+		}
+		if entry.Token != token {
+			// left by a page which has been fully handled
+			continue
+		}
+		if entry.Found {
+			err = ij.Storage.Set(entry.Key, entry.Value)
+		} else {
+			err = ij.Storage.Delete(entry.Key)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to restore the index entry '%s': %w", entry.Key, err)
+			return
+		}
+		restored++
+	}
+	if err = ij.clear(nbEntries); err != nil {
+		return
+	}
+	ij.token = token
+	ij.saved = make(map[string]bool)
+	return
+}
+
+// commit stops journaling and removes the journal once the page has been fully handled
+func (ij *indexJournal) commit() (err error) {
+	nbEntries := ij.entries
+	ij.token = ""
+	ij.saved = nil
+	return ij.clear(nbEntries)
+}
+
+func (ij *indexJournal) save(key string) (err error) {
+	if ij.token == "" || ij.saved[key] {
+		return
+	}
+	entry := indexJournalEntry{
+		Token: ij.token,
+		Key:   key,
+	}
+	if entry.Found, err = ij.Storage.Get(key, &entry.Value); err != nil {
+		err = fmt.Errorf("failed to get the index entry '%s' to journal it: %w", key, err)
+		return
+	}
+	if err = ij.state.Set(journalEntryKey(ij.entries), entry); err != nil {
+		err = fmt.Errorf("failed to journal the index entry '%s': %w", key, err)
+		return
+	}
+	ij.entries++
+	ij.saved[key] = true
+	return
+}
+
+// clear removes the journal entries from the last one: an interrupted clear leaves them contiguous
+func (ij *indexJournal) clear(nbEntries int) (err error) {
+	for index := nbEntries - 1; index >= 0; index-- {
+		if err = ij.state.Delete(journalEntryKey(index)); err != nil {
+			err = fmt.Errorf("failed to remove the index journal entry #%d: %w", index, err)
+			return
+		}
+	}
+	ij.entries = 0
+	return
+}
+
+func journalEntryKey(index int) string {
+	return fmt.Sprintf("%s%d", stateIndexJournalPrefix, index)
+}
@@ -0,0 +1,177 @@
+package gdrive
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hekmon/rcgdip/drivechange"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const testChangeTime = "2022-03-30T12:00:00Z"
+
+func TestIndexJournal(t *testing.T) {
+	index := memStorage{}
+	state := memStorage{}
+	ij := newIndexJournal(index, state)
+	for key, value := range map[string]string{"a": "a0", "b": "b0"} {
+		if err := ij.Set(key, value); err != nil {
+			t.Fatalf("failed to set '%s': %s", key, err)
+		}
+	}
+	if len(state) != 0 {
+		t.Fatalf("expected nothing to be journaled before begin, got %d entries", len(state))
+	}
+	original := memStorage{"a": index["a"], "b": index["b"]}
+	modify := func() {
+		for _, err := range []error{ij.Set("a", "a1"), ij.Set("a", "a2"), ij.Delete("b"), ij.Set("c", "c1")} {
+			if err != nil {
+				t.Fatalf("failed to modify the index: %s", err)
+			}
+		}
+	}
+	// First processing of the page fails: the next one restores the index
+	if _, err := ij.begin("page1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	modify()
+	restored, err := ij.begin("page1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if restored != 3 {
+		t.Errorf("expected 3 restored entries, got %d", restored)
+	}
+	if !reflect.DeepEqual(index, original) {
+		t.Errorf("expected the index to be restored to %v, got %v", original, index)
+	}
+	// Second processing succeeds
+	modify()
+	if err = ij.commit(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(state) != 0 {
+		t.Errorf("expected the journal to be removed once committed, got %d entries", len(state))
+	}
+	modified := memStorage{"a": index["a"], "c": index["c"]}
+	if restored, err = ij.begin("page2"); err != nil || restored != 0 {
+		t.Errorf("expected nothing to restore for the next page, got %d (%v)", restored, err)
+	}
+	if !reflect.DeepEqual(index, modified) {
+		t.Errorf("expected the index to keep the committed page modifications %v, got %v", modified, index)
+	}
+	// A journal left by a fully handled page (interrupted commit) is dropped, not restored
+	modify()
+	if restored, err = ij.begin("page3"); err != nil || restored != 0 {
+		t.Errorf("expected nothing to restore from the journal of another page, got %d (%v)", restored, err)
+	}
+	if len(state) != 0 {
+		t.Errorf("expected the journal of another page to be removed, got %d entries", len(state))
+	}
+}
+
+// processPageTwice processes the changes page as the watcher does, the first time failing to send it
+func processPageTwice(t *testing.T, c *Controller, changes []*drive.Change) (first, second []drivechange.File) {
+	var err error
+	for attempt, result := range []*[]drivechange.File{&first, &second} {
+		if _, err = c.journal.begin("page"); err != nil {
+			t.Fatalf("attempt #%d: failed to begin the journal: %s", attempt+1, err)
+		}
+		if *result, err = c.getFilesChanges(changes); err != nil {
+			t.Fatalf("attempt #%d: unexpected error: %s", attempt+1, err)
+		}
+	}
+	return
+}
+
+func changesPaths(changedFiles []drivechange.File) (paths []string) {
+	for _, changedFile := range changedFiles {
+		state := "present"
+		if changedFile.Deleted {
+			state = "deleted"
+		}
+		paths = append(paths, state+":"+strings.Join(changedFile.Paths, ","))
+	}
+	return
+}
+
+func TestGetFilesChangesReplayRemoval(t *testing.T) {
+	c := newTestIndexController(t, "", "", map[string]driveFileBasicInfo{
+		"root":   {Name: "My Drive", Folder: true},
+		"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+		"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+	})
+	first, second := processPageTwice(t, c, []*drive.Change{
+		{ChangeType: "file", FileId: "file", Removed: true, Time: testChangeTime},
+	})
+	expected := []string{"deleted:Movies/a.mkv"}
+	if paths := changesPaths(first); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("first processing: expected %v, got %v", expected, paths)
+	}
+	if paths := changesPaths(second); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("second processing: expected %v, got %v", expected, paths)
+	}
+}
+
+func TestGetFilesChangesReplayMove(t *testing.T) {
+	c := newTestIndexController(t, "", "", map[string]driveFileBasicInfo{
+		"root":   {Name: "My Drive", Folder: true},
+		"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+		"kids":   {Name: "Kids", Folder: true, Parents: []string{"root"}},
+		"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+	})
+	first, second := processPageTwice(t, c, []*drive.Change{
+		{
+			ChangeType: "file",
+			FileId:     "file",
+			Time:       testChangeTime,
+			File:       &drive.File{Id: "file", Name: "b.mkv", MimeType: "video/x-matroska", Parents: []string{"kids"}},
+		},
+	})
+	expected := []string{"present:Kids/b.mkv", "deleted:Movies/a.mkv"}
+	if paths := changesPaths(first); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("first processing: expected %v, got %v", expected, paths)
+	}
+	if paths := changesPaths(second); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("second processing: expected %v, got %v", expected, paths)
+	}
+	if len(second) == 2 && (!second[1].Moved || second[1].ID != "file") {
+		t.Errorf("expected the previous location to be reported as moved away, got %+v", second[1])
+	}
+}
+
+func TestGetPreviousPathsReplayFolder(t *testing.T) {
+	c := newTestIndexController(t, "", "", map[string]driveFileBasicInfo{
+		"root":   {Name: "My Drive", Folder: true},
+		"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+	})
+	changes := []*drive.Change{
+		{
+			ChangeType: "file",
+			FileId:     "movies",
+			Time:       testChangeTime,
+			File:       &drive.File{Id: "movies", Name: "Films", MimeType: folderMimeType, Parents: []string{"root"}},
+		},
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, err := c.journal.begin("page"); err != nil {
+			t.Fatalf("attempt #%d: failed to begin the journal: %s", attempt, err)
+		}
+		previousPaths, unchangedFolders, err := c.getPreviousPaths(changes)
+		if err != nil {
+			t.Fatalf("attempt #%d: unexpected error: %s", attempt, err)
+		}
+		if expected := []string{"Movies"}; !reflect.DeepEqual(previousPaths["movies"], expected) {
+			t.Errorf("attempt #%d: previous paths: expected %v, got %v", attempt, expected, previousPaths["movies"])
+		}
+		if unchangedFolders["movies"] {
+			t.Errorf("attempt #%d: the renamed folder is considered unchanged", attempt)
+		}
+		// the page processing updates the index then fails to be sent
+		if err = c.addChangesFilesToIndex(changes); err != nil {
+			t.Fatalf("attempt #%d: failed to update the index: %s", attempt, err)
+		}
+	}
+}
@@ -39,6 +39,19 @@ type driveFilePathElem struct {
 }
 
 func (c *Controller) generateReversePaths(fileID string) (buildedPaths []driveFilePath, err error) {
+	return c.generateReversePathsWithin(fileID, make(map[string]bool))
+}
+
+// generateReversePathsWithin returns a nil slice if fileID is the root folder and an empty one if no path can be built.
+// The custom root folder indexed without parents (restricted scope) is kept within the paths to be cut at by validatePaths.
+// crossing contains the fileIDs of the path being built as shortcuts can create loops.
+func (c *Controller) generateReversePathsWithin(fileID string, crossing map[string]bool) (buildedPaths []driveFilePath, err error) {
+	if crossing[fileID] {
+		c.logger.Debugf("[Drive] fileID '%s' is already part of the path being built (shortcut loop), skipping this branch", fileID)
+		return []driveFilePath{}, nil
+	}
+	crossing[fileID] = true
+	defer delete(crossing, fileID)
 	// Obtain infos for current fileID
 	var fileInfos driveFileBasicInfo
 	found, err := c.index.Get(fileID, &fileInfos)
@@ -52,28 +65,49 @@ func (c *Controller) generateReversePaths(fileID string) (buildedPaths []driveFi
 	}
 	// Stop if no parent, we have reached root folder
 	if len(fileInfos.Parents) == 0 {
+		if c.rc.Drive.Options.RootFolderID != "" && fileID == c.rc.Drive.Options.RootFolderID {
+			buildedPaths = []driveFilePath{
+				{
+					{
+						ID:   fileID,
+						Name: c.standardName(fileInfos.Name),
+					},
+				},
+			}
+		}
 		return
 	}
+	// Use the name the mount shows
+	name := c.standardName(fileInfos.Name)
+	if fileInfos.DocType != "" {
+		extension, _ := c.documentExtension(fileInfos.DocType)
+		name += extension
+	}
 	// Follow the white rabbit
-	buildedPaths = make([]driveFilePath, len(fileInfos.Parents))
+	buildedPaths = make([]driveFilePath, 0, len(fileInfos.Parents))
 	var (
 		parentPaths []driveFilePath
 		currentPath driveFilePath
 	)
-	for parentIndex, parent := range fileInfos.Parents {
+	for _, parent := range fileInfos.Parents {
+		// Within the restricted scope, some parents might not be visible (and so not indexed): they are out of the tree
+		if c.rc.Drive.Options.Scope == restrictedScope && !c.index.Has(parent) {
+			c.logger.Debugf("[Drive] parent folderID '%s' of fileID '%s' is not within the index, skipping this branch", parent, fileID)
+			continue
+		}
 		// Get paths for this parent
-		if parentPaths, err = c.generateReversePaths(parent); err != nil {
+		if parentPaths, err = c.generateReversePathsWithin(parent, crossing); err != nil {
 			err = fmt.Errorf("failed to lookup parent path for folderID '%s': %w", parent, err)
 			return
 		}
 		// If parent is root folder, just add ourself in this path
 		if parentPaths == nil {
-			buildedPaths[parentIndex] = driveFilePath{
+			buildedPaths = append(buildedPaths, driveFilePath{
 				{
 					ID:   fileID,
-					Name: fileInfos.Name,
+					Name: name,
 				},
-			}
+			})
 			continue
 		}
 		// Else add paths to final return while prefixing with current file/folder name
@@ -82,16 +116,38 @@ func (c *Controller) generateReversePaths(fileID string) (buildedPaths []driveFi
 			// prefix ourself
 			currentPath[0] = driveFilePathElem{
 				ID:   fileID,
-				Name: fileInfos.Name,
+				Name: name,
 			}
 			// add expanded parents
 			for parentPathElemIndex, parentPathElem := range parentPath {
 				currentPath[parentPathElemIndex+1] = parentPathElem
 			}
 			// save this new builded path with parents for return
-			buildedPaths[parentIndex] = currentPath
+			buildedPaths = append(buildedPaths, currentPath)
 		}
 	}
-	// All parents paths explored
+	// The file (or folder) is also visible at the location of each shortcut pointing to it
+	shortcuts, err := c.getShortcutsTo(fileID)
+	if err != nil {
+		return
+	}
+	for _, shortcutID := range shortcuts {
+		if !c.index.Has(shortcutID) {
+			c.logger.Debugf("[Drive] shortcut fileID '%s' to fileID '%s' is not within the index, skipping this branch", shortcutID, fileID)
+			continue
+		}
+		if parentPaths, err = c.generateReversePathsWithin(shortcutID, crossing); err != nil {
+			err = fmt.Errorf("failed to lookup shortcut path for fileID '%s': %w", shortcutID, err)
+			return
+		}
+		buildedPaths = append(buildedPaths, parentPaths...)
+	}
+	// All parents and shortcuts paths explored
 	return
 }
+
+// standardName decodes a Drive name with the encoding of the rclone drive backend (eg '／' for '/' or invalid UTF-8 quoting).
+// Crypt decryption also expects these standard names.
+func (c *Controller) standardName(driveName string) string {
+	return c.rc.Drive.Options.Enc.ToStandardName(driveName)
+}
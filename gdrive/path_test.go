@@ -0,0 +1,180 @@
+package gdrive
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hekmon/hllogger/v2"
+	"github.com/hekmon/rcgdip/gdrive/rcsnooper"
+)
+
+// memStorage is an in memory Storage
+type memStorage map[string][]byte
+
+func (ms memStorage) Clear() error {
+	for key := range ms {
+		delete(ms, key)
+	}
+	return nil
+}
+
+func (ms memStorage) Delete(key string) error {
+	delete(ms, key)
+	return nil
+}
+
+func (ms memStorage) Get(key string, value interface{}) (bool, error) {
+	data, found := ms[key]
+	if !found {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (ms memStorage) Has(key string) bool {
+	_, found := ms[key]
+	return found
+}
+
+func (ms memStorage) Keys() (keys []string) {
+	for key := range ms {
+		keys = append(keys, key)
+	}
+	return
+}
+
+func (ms memStorage) KeysWithPrefix(prefix string) (keys []string, err error) {
+	for key := range ms {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+func (ms memStorage) NbKeys() int {
+	return len(ms)
+}
+
+func (ms memStorage) Set(key string, value interface{}) (err error) {
+	ms[key], err = json.Marshal(value)
+	return
+}
+
+func (ms memStorage) Sync() error {
+	return nil
+}
+
+func newTestIndexController(t *testing.T, scope, rootFolderID string, index map[string]driveFileBasicInfo) *Controller {
+	c := &Controller{
+		logger: hllogger.New(ioutil.Discard, hllogger.Debug),
+		rc:     &rcsnooper.Controller{},
+		state:  make(memStorage),
+	}
+	c.journal = newIndexJournal(make(memStorage), c.state)
+	c.index = c.journal
+	c.rc.Drive.Options.Scope = scope
+	c.rc.Drive.Options.RootFolderID = rootFolderID
+	for fileID, fileInfo := range index {
+		if err := c.index.Set(fileID, fileInfo); err != nil {
+			t.Fatalf("failed to index fileID '%s': %s", fileID, err)
+		}
+	}
+	return c
+}
+
+func TestGenerateValidPaths(t *testing.T) {
+	testCases := []struct {
+		name         string
+		scope        string
+		rootFolderID string
+		index        map[string]driveFileBasicInfo
+		paths        []string
+	}{
+		{
+			name: "drive root",
+			index: map[string]driveFileBasicInfo{
+				"root":   {Name: "My Drive", Folder: true},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+			},
+			paths: []string{"Movies/a.mkv"},
+		},
+		{
+			name:         "custom root",
+			rootFolderID: "custom",
+			index: map[string]driveFileBasicInfo{
+				"root":   {Name: "My Drive", Folder: true},
+				"custom": {Name: "Media", Folder: true, Parents: []string{"root"}},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"custom"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+			},
+			paths: []string{"Movies/a.mkv"},
+		},
+		{
+			name:         "custom root outside",
+			rootFolderID: "custom",
+			index: map[string]driveFileBasicInfo{
+				"root":   {Name: "My Drive", Folder: true},
+				"custom": {Name: "Media", Folder: true, Parents: []string{"root"}},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+			},
+			paths: []string{},
+		},
+		{
+			name:         "restricted scope custom root",
+			scope:        restrictedScope,
+			rootFolderID: "custom",
+			index: map[string]driveFileBasicInfo{
+				"root":   {Name: "My Drive", Folder: true},
+				"custom": {Name: "Media", Folder: true},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"custom"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies"}},
+			},
+			paths: []string{"Movies/a.mkv"},
+		},
+		{
+			name:         "restricted scope outside custom root",
+			scope:        restrictedScope,
+			rootFolderID: "custom",
+			index: map[string]driveFileBasicInfo{
+				"root":   {Name: "My Drive", Folder: true},
+				"custom": {Name: "Media", Folder: true},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"root"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies", "unknown"}},
+			},
+			paths: []string{},
+		},
+		{
+			name:         "restricted scope multiple parents",
+			scope:        restrictedScope,
+			rootFolderID: "custom",
+			index: map[string]driveFileBasicInfo{
+				"custom": {Name: "Media", Folder: true},
+				"movies": {Name: "Movies", Folder: true, Parents: []string{"custom"}},
+				"kids":   {Name: "Kids", Folder: true, Parents: []string{"custom"}},
+				"file":   {Name: "a.mkv", Parents: []string{"movies", "kids", "unknown"}},
+			},
+			paths: []string{"Kids/a.mkv", "Movies/a.mkv"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestIndexController(t, tc.scope, tc.rootFolderID, tc.index)
+			reversedPaths, err := c.generateReversePaths("file")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			paths := c.validatePaths(reversedPaths)
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, tc.paths) {
+				t.Errorf("expected %v, got %v", tc.paths, paths)
+			}
+		})
+	}
+}
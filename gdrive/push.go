@@ -0,0 +1,163 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	pushChannelTTL          = 24 * time.Hour
+	pushChannelRenewMargin  = 5 * time.Minute
+	pushStopTimeout         = 10 * time.Second
+	pushSafetyNetInterval   = 15 * time.Minute
+	passRetryDelay          = 30 * time.Second
+	pushChannelTokenHeader  = "X-Goog-Channel-Token"
+	pushChannelIDHeader     = "X-Goog-Channel-ID"
+	pushResourceStateHeader = "X-Goog-Resource-State"
+)
+
+func (c *Controller) startPushListener(listen string) (err error) {
+	// Generate the secret token sent back by Google with each notification
+	token, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("failed to generate the push channel token: %w", err)
+		return
+	}
+	c.pushToken = token.String()
+	c.pushNotif = make(chan struct{}, 1)
+	// Bind now to report errors at init
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		err = fmt.Errorf("failed to listen on '%s': %w", listen, err)
+		return
+	}
+	server := &http.Server{
+		Handler:      http.HandlerFunc(c.pushHandler),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	// Serve until main context is cancelled
+	c.workers.Add(2)
+	go func() {
+		defer c.workers.Done()
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			c.logger.Errorf("[Drive] push notifications listener failed: %s", err)
+		}
+	}()
+	go func() {
+		defer c.workers.Done()
+		<-c.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), pushStopTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			c.logger.Errorf("[Drive] failed to cleanly stop the push notifications listener: %s", err)
+		}
+	}()
+	c.logger.Infof("[Drive] push notifications listener started on %s", listener.Addr())
+	return
+}
+
+func (c *Controller) pushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Only accept notifications from our channels
+	if r.Header.Get(pushChannelTokenHeader) != c.pushToken {
+		c.logger.Warningf("[Drive] received a push notification with an invalid token from %s: discarding it", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	// The first message of a channel is a sync message, nothing has changed
+	state := r.Header.Get(pushResourceStateHeader)
+	if state == "sync" {
+		c.logger.Debugf("[Drive] push notifications channel '%s' sync message received", r.Header.Get(pushChannelIDHeader))
+		return
+	}
+	c.logger.Debugf("[Drive] push notification received on channel '%s': %s", r.Header.Get(pushChannelIDHeader), state)
+	// Wake up the watcher (if a pass is already pending, this notification will be handled by it)
+	select {
+	case c.pushNotif <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Controller) pushActive() bool {
+	return c.pushChannel != nil && time.Now().Before(c.pushExpiration)
+}
+
+// pollDue returns true if changes should be polled: push notifications are not active or none has been processed for a while (a notification might have been lost)
+func (c *Controller) pollDue() bool {
+	return !c.pushActive() || time.Since(c.lastPass) >= pushSafetyNetInterval
+}
+
+func (c *Controller) pushRenew(fallback time.Duration) (renewIn time.Duration) {
+	// Prepare the new channel
+	channelID, err := uuid.NewV4()
+	if err != nil {
+		c.logger.Errorf("[Drive] failed to generate a push channel ID: %s", err)
+		return fallback
+	}
+	var pageToken string
+	if _, err = c.state.Get(stateNextStartPageKey, &pageToken); err != nil {
+		c.logger.Errorf("[Drive] failed to get the start page token from stored state for push channel registration: %s", err)
+		return fallback
+	}
+	// Register it
+	registered, err := c.getDriveChangesWatch(pageToken, &drive.Channel{
+		Id:         channelID.String(),
+		Type:       "web_hook",
+		Address:    c.pushURL,
+		Token:      c.pushToken,
+		Expiration: time.Now().Add(pushChannelTTL).UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		if c.pushActive() {
+			c.logger.Errorf("[Drive] failed to renew the push notifications channel, will retry in %v: %s", fallback, err)
+		} else {
+			c.logger.Errorf("[Drive] failed to register a push notifications channel, falling back to polling every %v: %s", fallback, err)
+		}
+		return fallback
+	}
+	// Stop the previous one now that the new one is active
+	if c.pushChannel != nil {
+		if err = c.stopDriveChannel(c.ctx, c.pushChannel); err != nil {
+			c.logger.Warningf("[Drive] failed to stop the previous push notifications channel '%s', it will expire by itself: %s",
+				c.pushChannel.Id, err)
+		}
+	}
+	c.pushChannel = registered
+	c.pushExpiration = time.Unix(0, registered.Expiration*int64(time.Millisecond))
+	c.logger.Infof("[Drive] push notifications channel active until %v", c.pushExpiration)
+	// Renew before expiration
+	if renewIn = time.Until(c.pushExpiration) - pushChannelRenewMargin; renewIn <= 0 {
+		renewIn = fallback
+	}
+	return
+}
+
+func (c *Controller) pushStop() {
+	if !c.pushActive() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushStopTimeout)
+	defer cancel()
+	if err := c.stopDriveChannel(ctx, c.pushChannel); err != nil {
+		c.logger.Warningf("[Drive] failed to stop the push notifications channel '%s', it will expire by itself: %s", c.pushChannel.Id, err)
+	}
+	c.pushChannel = nil
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,178 @@
+package gdrive
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hekmon/hllogger/v2"
+	"google.golang.org/api/drive/v3"
+)
+
+const testPushToken = "push-token"
+
+func newTestPushController() *Controller {
+	return &Controller{
+		ctx:       context.Background(),
+		logger:    hllogger.New(ioutil.Discard, hllogger.Debug),
+		pushToken: testPushToken,
+		pushNotif: make(chan struct{}, 1),
+	}
+}
+
+// postNotification acts as Google posting a push notification
+func postNotification(t *testing.T, url, method, token, state string) (statusCode int) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("failed to build the notification request: %s", err)
+	}
+	req.Header.Set(pushChannelIDHeader, "channel")
+	req.Header.Set(pushResourceStateHeader, state)
+	if token != "" {
+		req.Header.Set(pushChannelTokenHeader, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post the notification: %s", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func pendingWakeUps(c *Controller) (nb int) {
+	for {
+		select {
+		case <-c.pushNotif:
+			nb++
+		default:
+			return
+		}
+	}
+}
+
+func TestPushHandler(t *testing.T) {
+	c := newTestPushController()
+	server := httptest.NewServer(http.HandlerFunc(c.pushHandler))
+	defer server.Close()
+	testCases := []struct {
+		name       string
+		method     string
+		token      string
+		state      string
+		statusCode int
+		wakeUp     bool
+	}{
+		{name: "change", method: http.MethodPost, token: testPushToken, state: "change", statusCode: http.StatusOK, wakeUp: true},
+		{name: "sync", method: http.MethodPost, token: testPushToken, state: "sync", statusCode: http.StatusOK},
+		{name: "invalid token", method: http.MethodPost, token: "not-the-token", state: "change", statusCode: http.StatusForbidden},
+		{name: "missing token", method: http.MethodPost, state: "change", statusCode: http.StatusForbidden},
+		{name: "invalid method", method: http.MethodGet, token: testPushToken, state: "change", statusCode: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if statusCode := postNotification(t, server.URL, tc.method, tc.token, tc.state); statusCode != tc.statusCode {
+				t.Errorf("status code: expected %d, got %d", tc.statusCode, statusCode)
+			}
+			expected := 0
+			if tc.wakeUp {
+				expected = 1
+			}
+			if nb := pendingWakeUps(c); nb != expected {
+				t.Errorf("wake ups: expected %d, got %d", expected, nb)
+			}
+		})
+	}
+}
+
+func TestPushHandlerCoalescing(t *testing.T) {
+	c := newTestPushController()
+	server := httptest.NewServer(http.HandlerFunc(c.pushHandler))
+	defer server.Close()
+	// Notifications received while a pass is pending are handled by it
+	for i := 0; i < 5; i++ {
+		if statusCode := postNotification(t, server.URL, http.MethodPost, testPushToken, "change"); statusCode != http.StatusOK {
+			t.Fatalf("notification #%d: expected status code %d, got %d", i+1, http.StatusOK, statusCode)
+		}
+	}
+	if nb := pendingWakeUps(c); nb != 1 {
+		t.Errorf("expected the 5 notifications to be coalesced in 1 wake up, got %d", nb)
+	}
+	// Once the pass has started, a new notification triggers a new one
+	if statusCode := postNotification(t, server.URL, http.MethodPost, testPushToken, "update"); statusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, statusCode)
+	}
+	if nb := pendingWakeUps(c); nb != 1 {
+		t.Errorf("expected 1 wake up after the pass has started, got %d", nb)
+	}
+}
+
+func TestPushListener(t *testing.T) {
+	// Find a free local port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %s", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	// Start the listener
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestPushController()
+	c.ctx = ctx
+	if err = c.startPushListener(addr); err != nil {
+		cancel()
+		t.Fatalf("failed to start the push listener: %s", err)
+	}
+	defer func() {
+		cancel()
+		c.workers.Wait()
+	}()
+	if c.pushToken == "" || c.pushToken == testPushToken {
+		t.Fatalf("expected a newly generated channel token, got '%s'", c.pushToken)
+	}
+	// Post a notification and wait for the wake up
+	if statusCode := postNotification(t, "http://"+addr, http.MethodPost, c.pushToken, "change"); statusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, statusCode)
+	}
+	select {
+	case <-c.pushNotif:
+	case <-time.After(time.Second):
+		t.Fatal("the notification did not wake up the watcher")
+	}
+}
+
+func TestPollDue(t *testing.T) {
+	channel := &drive.Channel{Id: "channel"}
+	testCases := []struct {
+		name       string
+		channel    *drive.Channel
+		expiration time.Time
+		lastPass   time.Time
+		active     bool
+		due        bool
+	}{
+		{name: "no channel", due: true},
+		{name: "active channel", channel: channel, expiration: time.Now().Add(time.Hour), lastPass: time.Now(), active: true},
+		{name: "safety net", channel: channel, expiration: time.Now().Add(time.Hour), lastPass: time.Now().Add(-pushSafetyNetInterval),
+			active: true, due: true},
+		{name: "never checked", channel: channel, expiration: time.Now().Add(time.Hour), active: true, due: true},
+		{name: "expired channel", channel: channel, expiration: time.Now().Add(-time.Second), lastPass: time.Now(), due: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestPushController()
+			c.pushChannel = tc.channel
+			c.pushExpiration = tc.expiration
+			c.lastPass = tc.lastPass
+			if active := c.pushActive(); active != tc.active {
+				t.Errorf("push active: expected %v, got %v", tc.active, active)
+			}
+			if due := c.pollDue(); due != tc.due {
+				t.Errorf("poll due: expected %v, got %v", tc.due, due)
+			}
+		})
+	}
+}
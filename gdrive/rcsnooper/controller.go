@@ -71,6 +71,15 @@ func (c *Controller) Summary() string {
 
 	b = append(b, fmt.Sprintf("config path: %s", c.Conf.RCloneConfigPath))
 	b = append(b, fmt.Sprintf("drive backend: %s", c.Conf.DriveBackendName))
+	if c.Drive.ServiceAccount != nil {
+		if c.Drive.Options.Impersonate != "" {
+			b = append(b, fmt.Sprintf("auth: service account impersonating %s", c.Drive.Options.Impersonate))
+		} else {
+			b = append(b, "auth: service account")
+		}
+	} else {
+		b = append(b, "auth: oauth2")
+	}
 	if c.Drive.Options.RootFolderID != "" {
 		b = append(b, fmt.Sprintf("custom root folderID: %s", c.Drive.Options.RootFolderID))
 	} else {
@@ -4,19 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/rclone/rclone/backend/drive"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/lib/env"
 	"golang.org/x/oauth2"
 )
 
 type DriveBackend struct {
-	Options      drive.Options
-	ClientID     string
-	ClientSecret string
-	Token        *oauth2.Token
+	Options        drive.Options
+	ClientID       string
+	ClientSecret   string
+	Token          *oauth2.Token
+	ServiceAccount []byte
 }
 
 func (c *Controller) extractDriveBackend(backend string) (err error) {
@@ -31,6 +34,17 @@ func (c *Controller) extractDriveBackend(backend string) (err error) {
 	if err = configstruct.Set(conf, &c.Drive.Options); err != nil {
 		return fmt.Errorf("can not extract config of the backend '%s' as drive options: %w", backend, err)
 	}
+	// Service account takes precedence over oauth2 (same as rclone): credentials first then file
+	if c.Drive.Options.ServiceAccountCredentials != "" {
+		c.Drive.ServiceAccount = []byte(c.Drive.Options.ServiceAccountCredentials)
+		return
+	}
+	if c.Drive.Options.ServiceAccountFile != "" {
+		if c.Drive.ServiceAccount, err = ioutil.ReadFile(env.ShellExpand(c.Drive.Options.ServiceAccountFile)); err != nil {
+			return fmt.Errorf("failed to read service account credentials file: %w", err)
+		}
+		return
+	}
 	// Extract values we need not within options
 	var found bool
 	if c.Drive.ClientID, found = conf.Get(config.ConfigClientID); !found || c.Drive.ClientID == "" {
@@ -40,14 +54,11 @@ func (c *Controller) extractDriveBackend(backend string) (err error) {
 		return fmt.Errorf("%s must be set", config.ConfigClientSecret)
 	}
 	tokenRaw, found := conf.Get(config.ConfigToken)
-	if found {
-		if err = json.Unmarshal([]byte(tokenRaw), &c.Drive.Token); err != nil {
-			return fmt.Errorf("failed to parse oauth2 token: %w", err)
-		}
-	} else if c.Drive.Options.ServiceAccountFile != "" {
-		return errors.New("authentification with service account not yet implemented")
-	} else {
+	if !found {
 		return errors.New("no suitable authentification found (oauth2 or service account)")
 	}
+	if err = json.Unmarshal([]byte(tokenRaw), &c.Drive.Token); err != nil {
+		return fmt.Errorf("failed to parse oauth2 token: %w", err)
+	}
 	return
 }
@@ -0,0 +1,125 @@
+package rcsnooper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testServiceAccount = `{"type":"service_account","client_email":"rcgdip@project.iam.gserviceaccount.com"}`
+	testFileAccount    = `{"type":"service_account","client_email":"file@project.iam.gserviceaccount.com"}`
+	testToken          = `{"access_token":"accesstoken","token_type":"Bearer","refresh_token":"refreshtoken","expiry":"2022-03-30T12:00:00Z"}`
+)
+
+func writeTestConfig(t *testing.T) (configPath string) {
+	dir := t.TempDir()
+	saFile := filepath.Join(dir, "sa.json")
+	if err := ioutil.WriteFile(saFile, []byte(testFileAccount), 0600); err != nil {
+		t.Fatalf("failed to write the service account file: %s", err)
+	}
+	t.Setenv("RCGDIP_TEST_SA_DIR", dir)
+	config := fmt.Sprintf(`[oauth]
+type = drive
+scope = drive
+client_id = clientid
+client_secret = clientsecret
+token = %[1]s
+
+[credentials]
+type = drive
+scope = drive
+service_account_credentials = %[2]s
+impersonate = user@example.com
+
+[file]
+type = drive
+scope = drive.readonly
+service_account_file = $RCGDIP_TEST_SA_DIR/sa.json
+
+[both]
+type = drive
+scope = drive
+service_account_credentials = %[2]s
+service_account_file = %[3]s
+client_id = clientid
+client_secret = clientsecret
+token = %[1]s
+
+[missingfile]
+type = drive
+scope = drive
+service_account_file = %[4]s
+
+[noauth]
+type = drive
+scope = drive
+client_id = clientid
+client_secret = clientsecret
+
+[notdrive]
+type = local
+`, testToken, testServiceAccount, saFile, filepath.Join(dir, "missing.json"))
+	configPath = filepath.Join(dir, "rclone.conf")
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatalf("failed to write the rclone config file: %s", err)
+	}
+	return
+}
+
+func TestExtractDriveBackend(t *testing.T) {
+	configPath := writeTestConfig(t)
+	testCases := []struct {
+		backend        string
+		serviceAccount string
+		impersonate    string
+		scope          string
+		oauth2         bool
+		fail           bool
+	}{
+		{backend: "oauth", scope: "drive", oauth2: true},
+		{backend: "credentials", scope: "drive", serviceAccount: testServiceAccount, impersonate: "user@example.com"},
+		{backend: "file", scope: "drive.readonly", serviceAccount: testFileAccount},
+		{backend: "both", scope: "drive", serviceAccount: testServiceAccount},
+		{backend: "missingfile", fail: true},
+		{backend: "noauth", fail: true},
+		{backend: "notdrive", fail: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.backend, func(t *testing.T) {
+			rc, err := New(Config{
+				RCloneConfigPath: configPath,
+				DriveBackendName: tc.backend,
+			})
+			if tc.fail {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rc.Drive.Options.Scope != tc.scope {
+				t.Errorf("scope: expected '%s', got '%s'", tc.scope, rc.Drive.Options.Scope)
+			}
+			if rc.Drive.Options.Impersonate != tc.impersonate {
+				t.Errorf("impersonate: expected '%s', got '%s'", tc.impersonate, rc.Drive.Options.Impersonate)
+			}
+			if string(rc.Drive.ServiceAccount) != tc.serviceAccount {
+				t.Errorf("service account: expected '%s', got '%s'", tc.serviceAccount, rc.Drive.ServiceAccount)
+			}
+			if tc.oauth2 {
+				if rc.Drive.Token == nil || rc.Drive.Token.AccessToken != "accesstoken" {
+					t.Errorf("token: expected access token 'accesstoken', got %+v", rc.Drive.Token)
+				}
+				if rc.Drive.ClientID != "clientid" || rc.Drive.ClientSecret != "clientsecret" {
+					t.Errorf("client: expected 'clientid'/'clientsecret', got '%s'/'%s'", rc.Drive.ClientID, rc.Drive.ClientSecret)
+				}
+			} else if rc.Drive.Token != nil {
+				t.Errorf("token: expected none as the service account takes precedence, got %+v", rc.Drive.Token)
+			}
+		})
+	}
+}
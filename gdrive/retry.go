@@ -0,0 +1,136 @@
+package gdrive
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	retryMaxAttempts     = 8
+	retryBaseDelay       = time.Second
+	retryMaxDelay        = 64 * time.Second
+	throttleCooldown     = time.Minute
+	throttleMinPerMinute = requestPerMin / 16
+)
+
+// apiCall waits for the limiter then executes the request, retrying with backoff on transient errors
+func (c *Controller) apiCall(what string, request func() error) (err error) {
+	var retryIn time.Duration
+	for attempt := 1; ; attempt++ {
+		// Execute the request
+		if err = c.limiter.Wait(c.ctx); err != nil {
+			err = fmt.Errorf("can not execute API request, waiting for the limiter failed: %w", err)
+			return
+		}
+		if err = request(); err == nil {
+			c.throttleRecover()
+			return
+		}
+		// Should we retry ?
+		retryable, throttled := c.classifyError(err)
+		if !retryable || c.ctx.Err() != nil {
+			return
+		}
+		if throttled {
+			c.throttle()
+		}
+		if attempt >= retryMaxAttempts {
+			err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			return
+		}
+		// Compute backoff: exponential with full jitter, unless the server told us how long to wait
+		if retryIn = retryAfter(err); retryIn == 0 {
+			backoff := retryBaseDelay << (attempt - 1)
+			if backoff > retryMaxDelay {
+				backoff = retryMaxDelay
+			}
+			retryIn = time.Duration(rand.Int63n(int64(backoff))) + time.Millisecond
+		}
+		c.logger.Warningf("[Drive] %s failed (attempt %d/%d), retrying in %v: %s", what, attempt, retryMaxAttempts, retryIn, err)
+		// Wait
+		timer := time.NewTimer(retryIn)
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (c *Controller) classifyError(err error) (retryable, throttled bool) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests:
+			return true, true
+		case apiErr.Code >= http.StatusInternalServerError:
+			return true, false
+		case apiErr.Code == http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				switch item.Reason {
+				case "userRateLimitExceeded", "rateLimitExceeded":
+					return true, true
+				}
+			}
+		}
+		return false, false
+	}
+	// Transport errors (connection reset, timeout, etc...)
+	var urlErr *url.Error
+	return errors.As(err, &urlErr), false
+}
+
+func retryAfter(err error) (delay time.Duration) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return
+	}
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return
+	}
+	if seconds, parseErr := strconv.Atoi(value); parseErr == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, parseErr := http.ParseTime(value); parseErr == nil {
+		if delay = time.Until(date); delay < 0 {
+			delay = 0
+		}
+	}
+	return
+}
+
+func (c *Controller) throttle() {
+	c.lastThrottle = time.Now()
+	newLimit := c.limiter.Limit() / 2
+	if minLimit := rate.Every(time.Minute / throttleMinPerMinute); newLimit < minLimit {
+		newLimit = minLimit
+	}
+	if newLimit != c.limiter.Limit() {
+		c.limiter.SetLimit(newLimit)
+		c.logger.Warningf("[Drive] throttled by the API: lowering our rate limit to %.0f request(s) per minute", float64(newLimit)*60)
+	}
+}
+
+func (c *Controller) throttleRecover() {
+	nominal := rate.Every(time.Minute / requestPerMin)
+	if c.limiter.Limit() >= nominal || time.Since(c.lastThrottle) < throttleCooldown {
+		return
+	}
+	newLimit := c.limiter.Limit() * 2
+	if newLimit > nominal {
+		newLimit = nominal
+	}
+	c.limiter.SetLimit(newLimit)
+	c.lastThrottle = time.Now() // wait another cooldown before the next step
+	c.logger.Infof("[Drive] no throttling for a while: raising our rate limit to %.0f request(s) per minute", float64(newLimit)*60)
+}
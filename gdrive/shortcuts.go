@@ -0,0 +1,116 @@
+package gdrive
+
+import (
+	"fmt"
+)
+
+const (
+	// index key listing the shortcuts pointing to a fileID (':' can not be found within a fileID)
+	indexShortcutsPrefix = "shortcutsTo:"
+)
+
+// hiddenFile returns true if rclone does not expose the file on the mount
+func (c *Controller) hiddenFile(fileInfo driveFileBasicInfo) bool {
+	return c.hiddenShortcut(fileInfo) || c.hiddenDocument(fileInfo.DocType)
+}
+
+func (c *Controller) hiddenShortcut(fileInfo driveFileBasicInfo) bool {
+	return fileInfo.ShortcutTarget != "" && c.rc.Drive.Options.SkipShortcuts
+}
+
+// indexFile saves the file infos within the index and keeps track of the children of each folder and of the shortcuts pointing to each target
+func (c *Controller) indexFile(fileID string, fileInfo driveFileBasicInfo) (err error) {
+	var (
+		previous driveFileBasicInfo
+		found    bool
+	)
+	if found, err = c.index.Get(fileID, &previous); err != nil {
+		err = fmt.Errorf("failed to get fileID '%s' previous infos from local index: %w", fileID, err)
+		return
+	}
+	if err = c.index.Set(fileID, fileInfo); err != nil {
+		return
+	}
+	if !found {
+		previous.Parents = nil
+	}
+	if err = c.updateChildren(fileID, previous.Parents, fileInfo.Parents); err != nil {
+		return
+	}
+	if fileInfo.ShortcutTarget == "" || c.rc.Drive.Options.SkipShortcuts {
+		return
+	}
+	shortcuts, err := c.getShortcutsTo(fileInfo.ShortcutTarget)
+	if err != nil {
+		return
+	}
+	for _, shortcutID := range shortcuts {
+		if shortcutID == fileID {
+			return
+		}
+	}
+	if err = c.index.Set(indexShortcutsPrefix+fileInfo.ShortcutTarget, append(shortcuts, fileID)); err != nil {
+		err = fmt.Errorf("failed to save the shortcuts pointing to fileID '%s': %w", fileInfo.ShortcutTarget, err)
+	}
+	return
+}
+
+// unindexFile removes the file infos from the index, along with its references as a child and as a shortcut
+func (c *Controller) unindexFile(fileID string) (err error) {
+	var (
+		fileInfo driveFileBasicInfo
+		found    bool
+	)
+	if found, err = c.index.Get(fileID, &fileInfo); err != nil {
+		err = fmt.Errorf("failed to get fileID '%s' infos from local index: %w", fileID, err)
+		return
+	}
+	if err = c.updateChildren(fileID, fileInfo.Parents, nil); err != nil {
+		return
+	}
+	if found && fileInfo.ShortcutTarget != "" {
+		var shortcuts []string
+		if shortcuts, err = c.getShortcutsTo(fileInfo.ShortcutTarget); err != nil {
+			return
+		}
+		remaining := make([]string, 0, len(shortcuts))
+		for _, shortcutID := range shortcuts {
+			if shortcutID != fileID {
+				remaining = append(remaining, shortcutID)
+			}
+		}
+		if len(remaining) == 0 {
+			err = c.index.Delete(indexShortcutsPrefix + fileInfo.ShortcutTarget)
+		} else {
+			err = c.index.Set(indexShortcutsPrefix+fileInfo.ShortcutTarget, remaining)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to update the shortcuts pointing to fileID '%s': %w", fileInfo.ShortcutTarget, err)
+			return
+		}
+	}
+	return c.index.Delete(fileID)
+}
+
+func (c *Controller) getShortcutsTo(targetID string) (shortcuts []string, err error) {
+	if c.rc.Drive.Options.SkipShortcuts {
+		return
+	}
+	if _, err = c.index.Get(indexShortcutsPrefix+targetID, &shortcuts); err != nil {
+		err = fmt.Errorf("failed to get the shortcuts pointing to fileID '%s' from local index: %w", targetID, err)
+	}
+	return
+}
+
+// resolveShortcut returns the fileID of the shortcut target if fileID is a shortcut, fileID otherwise
+func (c *Controller) resolveShortcut(fileID string) (targetID string, err error) {
+	var fileInfo driveFileBasicInfo
+	if _, err = c.index.Get(fileID, &fileInfo); err != nil {
+		err = fmt.Errorf("failed to get fileID '%s' infos from local index: %w", fileID, err)
+		return
+	}
+	if fileInfo.ShortcutTarget == "" || c.rc.Drive.Options.SkipShortcuts {
+		return fileID, nil
+	}
+	return fileInfo.ShortcutTarget, nil
+}
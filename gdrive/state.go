@@ -6,9 +6,17 @@ import (
 )
 
 const (
-	stateRootFolderIDKey  = "rootFolderID"
-	stateNextStartPageKey = "nextStartPage"
-	stateIndexOK          = "indexOK"
+	stateRootFolderIDKey    = "rootFolderID"
+	stateNextStartPageKey   = "nextStartPage"
+	stateIndexOK            = "indexOK"
+	stateIndexProgressKey   = "indexProgress"
+	stateIndexWalkPrefix    = "indexWalk_"
+	stateIndexJournalPrefix = "indexJournal_" // index journal entries, numbered from 0
+	stateIndexVersionKey    = "indexVersion"
+	// indexVersion must be increased each time the index content changes: an index built by a previous version is rebuilt
+	// 2: Google Docs type, shortcuts target and shortcuts reverse index
+	// 3: children index
+	indexVersion = 3
 )
 
 func (c *Controller) validateState() (err error) {
@@ -50,7 +58,19 @@ func (c *Controller) validateState() (err error) {
 		c.logger.Warningf("[Drive] rootID has changed (%s -> %s): reiniting local state", storedRootID, remoteRootID)
 		return
 	}
-	c.logger.Debug("[Drive] rootID recovered in our state matches the one upstream, checking metadata...")
+	c.logger.Debug("[Drive] rootID recovered in our state matches the one upstream, checking index version...")
+	// Has the index been built with the current schema ?
+	var storedIndexVersion int
+	if _, err = c.state.Get(stateIndexVersionKey, &storedIndexVersion); err != nil {
+		err = fmt.Errorf("failed to get the index version from stored state: %w", err)
+		return
+	}
+	if storedIndexVersion != indexVersion {
+		c.logger.Warningf("[Drive] local index has been built by a previous version of rcgdip (index version %d, current is %d): reiniting local state",
+			storedIndexVersion, indexVersion)
+		return
+	}
+	c.logger.Debug("[Drive] index version is the current one, checking metadata...")
 	// Validate index based on root file info
 	var storedRootInfo driveFileBasicInfo
 	if found, err = c.index.Get(storedRootID, &storedRootInfo); err != nil {
@@ -79,8 +99,21 @@ func (c *Controller) validateState() (err error) {
 	}
 	// Is indexing complete ?
 	if !c.state.Has(stateIndexOK) {
-		c.logger.Warning("[Drive] local index is incomplete: reiniting local state")
-		return
+		if !c.state.Has(stateIndexProgressKey) {
+			c.logger.Warning("[Drive] local index is incomplete and no build progress has been saved: reiniting local state")
+			return
+		}
+		c.logger.Notice("[Drive] local index is incomplete: resuming its build from the last checkpoint")
+		if err = c.indexBuild(); err != nil {
+			if isBadRequest(err) {
+				// listing page tokens do not live forever
+				c.logger.Warningf("[Drive] failed to resume the index build from the last checkpoint: reiniting local state: %s", err)
+				err = nil
+				return
+			}
+			err = fmt.Errorf("failed to resume the index build: %w", err)
+			return
+		}
 	}
 	// Does the custom root folderID exists within our index ?
 	if c.rc.Drive.Options.RootFolderID != "" && !c.index.Has(c.rc.Drive.Options.RootFolderID) {
@@ -107,6 +140,10 @@ func (c *Controller) reinitState(remoteRootID string, remoteRootInfos *driveFile
 		err = fmt.Errorf("failed to save root folder fileID within the local state: %w", err)
 		return
 	}
+	if err = c.state.Set(stateIndexVersionKey, indexVersion); err != nil {
+		err = fmt.Errorf("failed to save the index version within the local state: %w", err)
+		return
+	}
 	// Insert the first index item: root folder
 	if err = c.index.Set(remoteRootID, remoteRootInfos); err != nil {
 		err = fmt.Errorf("failed to save root folder file infos within the local index: %w", err)
@@ -122,11 +159,22 @@ func (c *Controller) reinitState(remoteRootID string, remoteRootInfos *driveFile
 		}
 	}
 	// Does the custom root folderID exists upstream ?
+	walkRootID := remoteRootID
 	if c.rc.Drive.Options.RootFolderID != "" {
-		if _, err = c.getDriveFileInfo(c.rc.Drive.Options.RootFolderID); err != nil {
+		var customRootInfos *driveFileBasicInfo
+		if customRootInfos, err = c.getDriveFileInfo(c.rc.Drive.Options.RootFolderID); err != nil {
 			err = fmt.Errorf("failed to validate rclone declared custom root folder ID upstream: %w", err)
 			return
 		}
+		if c.rc.Drive.Options.Scope == restrictedScope {
+			// the tree walk will start from it: index it without its parents as they might not be visible within the restricted scope
+			customRootInfos.Parents = nil
+			if err = c.index.Set(c.rc.Drive.Options.RootFolderID, customRootInfos); err != nil {
+				err = fmt.Errorf("failed to save custom root folder file infos within the local index: %w", err)
+				return
+			}
+			walkRootID = c.rc.Drive.Options.RootFolderID
+		}
 	}
 	// Get changes starting point
 	var nextStartPage string
@@ -139,12 +187,18 @@ func (c *Controller) reinitState(remoteRootID string, remoteRootInfos *driveFile
 		return
 	}
 	// Index all the things
-	if err = c.initialIndexBuild(); err != nil {
-		err = fmt.Errorf("failed to index the drive: %w", err)
+	if c.rc.Drive.Options.Scope == restrictedScope {
+		if err = c.state.Set(stateIndexWalkPrefix+walkRootID, true); err != nil {
+			err = fmt.Errorf("failed to save the walk root folderID within our state: %w", err)
+			return
+		}
+	}
+	if err = c.state.Set(stateIndexProgressKey, indexProgress{}); err != nil {
+		err = fmt.Errorf("failed to save the index build progress within our state: %w", err)
 		return
 	}
-	if err = c.state.Set(stateIndexOK, true); err != nil {
-		err = fmt.Errorf("failed to save the startPageToken within our state: %w", err)
+	if err = c.indexBuild(); err != nil {
+		err = fmt.Errorf("failed to index the drive: %w", err)
 		return
 	}
 	return
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/hekmon/rcgdip/drivechange"
+
+	"google.golang.org/api/drive/v3"
 )
 
 func (c *Controller) watcher(interval time.Duration) {
@@ -25,11 +27,48 @@ func (c *Controller) watcher(interval time.Duration) {
 	// Start the watch
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	c.logger.Infof("[Drive] will check for changes every %v", interval)
+	var (
+		renewTimer *time.Timer
+		renew      <-chan time.Time
+	)
+	if c.pushURL != "" {
+		renewTimer = time.NewTimer(c.pushRenew(interval))
+		defer renewTimer.Stop()
+		defer c.pushStop()
+		renew = renewTimer.C
+		c.logger.Infof("[Drive] will check for changes on push notifications (and at least every %v), or every %v if the channel is not active",
+			maxDuration(pushSafetyNetInterval, interval), interval)
+	} else {
+		c.logger.Infof("[Drive] will check for changes every %v", interval)
+	}
+	var retry <-chan time.Time
+	pass := func() {
+		if err := c.workerPass(); err != nil {
+			c.logger.Errorf("[Drive] failed to check changes, retrying in %v: %s", passRetryDelay, err)
+			retry = time.After(passRetryDelay)
+			return
+		}
+		c.lastPass = time.Now()
+		retry = nil
+	}
 	for {
 		select {
 		case <-ticker.C:
-			c.workerPass()
+			if !c.pollDue() {
+				c.logger.Debug("[Drive] push notifications channel is active: skipping poll")
+				continue
+			}
+			if c.pushActive() {
+				c.logger.Debugf("[Drive] no changes check for %v while the push notifications channel is active: polling as a safety net",
+					pushSafetyNetInterval)
+			}
+			pass()
+		case <-retry:
+			pass()
+		case <-c.pushNotif:
+			pass()
+		case <-renew:
+			renewTimer.Reset(c.pushRenew(interval))
 		case <-c.ctx.Done():
 			c.logger.Debug("[Drive] stopping watcher as main context has been cancelled")
 			return
@@ -37,14 +76,85 @@ func (c *Controller) watcher(interval time.Duration) {
 	}
 }
 
-func (c *Controller) workerPass() {
+func (c *Controller) workerPass() (err error) {
 	c.logger.Debug("[Drive] checking changes...")
-	// Compute the paths containing changes
-	changesFiles, err := c.getFilesChanges()
-	if err != nil {
-		c.logger.Errorf("failed to retreived changed files: %s", err)
+	// Recover where we stopped
+	var (
+		pageToken string
+		found     bool
+	)
+	if found, err = c.state.Get(stateNextStartPageKey, &pageToken); err != nil {
+		err = fmt.Errorf("failed to get the start page token from stored state: %w", err)
 		return
 	}
+	if !found {
+		err = errors.New("start page token not found within stored state")
+		return
+	}
+	// Process changes page by page, checkpointing the token after each one
+	var (
+		changes       []*drive.Change
+		changesFiles  []drivechange.File
+		nextPageToken string
+		newStartPage  string
+		nbChanges     int
+		restored      int
+	)
+	start := time.Now()
+	for {
+		// Get the page
+		if changes, nextPageToken, newStartPage, err = c.getDriveChanges(pageToken); err != nil {
+			err = fmt.Errorf("failed to retreive changes page: %w", err)
+			return
+		}
+		nbChanges += len(changes)
+		// The index is updated while computing the paths: journal it to be able to process the page again if it can not be fully handled
+		if restored, err = c.journal.begin(pageToken); err != nil {
+			err = fmt.Errorf("failed to start the index journal: %w", err)
+			return
+		}
+		if restored > 0 {
+			c.logger.Warningf("[Drive] changes page is processed again: %d index entries restored as they were before its previous processing", restored)
+		}
+		// Compute the paths containing changes and send them
+		if changesFiles, err = c.getFilesChanges(changes); err != nil {
+			err = fmt.Errorf("failed to retreive changed files: %w", err)
+			return
+		}
+		if err = c.sendChanges(changesFiles); err != nil {
+			err = fmt.Errorf("failed to send changed files: %w", err)
+			return
+		}
+		// Checkpoint
+		if nextPageToken != "" {
+			pageToken = nextPageToken
+		} else if newStartPage != pageToken {
+			// if no changes, token stays the same
+			pageToken = newStartPage
+		}
+		if err = c.state.Set(stateNextStartPageKey, pageToken); err != nil {
+			err = fmt.Errorf("failed to save the next page token within local state: %w", err)
+			return
+		}
+		if err = c.journal.commit(); err != nil {
+			// the next page will remove it
+			c.logger.Warningf("[Drive] failed to remove the index journal of the handled changes page: %s", err)
+			err = nil
+		}
+		// Last page ?
+		if nextPageToken == "" {
+			break
+		}
+	}
+	if nbChanges == 0 {
+		c.logger.Info("[Drive] no changes detected")
+	} else {
+		c.logger.Debugf("[Drive] %d raw change(s) processed in %v", nbChanges, time.Since(start))
+	}
+	return
+}
+
+func (c *Controller) sendChanges(changesFiles []drivechange.File) (err error) {
 	if len(changesFiles) == 0 {
 		return
 	}
@@ -76,7 +186,9 @@ func (c *Controller) workerPass() {
 				} else {
 					fileType = "file"
 				}
-				if change.Deleted {
+				if change.Moved {
+					deletedSuffix = " (moved away)"
+				} else if change.Deleted {
 					deletedSuffix = " (removed)"
 				} else {
 					deletedSuffix = ""
@@ -87,8 +199,12 @@ func (c *Controller) workerPass() {
 	}
 	// Send the collection to the consumer
 	c.logger.Debug("[Drive] sending change(s)...")
-	c.output <- changesFiles
+	if err = c.output.Enqueue(changesFiles); err != nil {
+		err = fmt.Errorf("failed to enqueue %d change(s): %w", len(changesFiles), err)
+		return
+	}
 	c.logger.Debugf("[Drive] sent %d change(s)", len(changesFiles))
+	return
 }
 
 func (c *Controller) processChangesThruCrypt(changesFiles []drivechange.File) (validCryptChangesFiles []drivechange.File) {
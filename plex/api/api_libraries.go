@@ -131,3 +131,34 @@ func (c *Client) ScanLibrary(ctx context.Context, key, specificPath string) (hea
 	}
 	return
 }
+
+var leafTypes = map[string]string{
+	"movie":  "1",
+	"show":   "4",
+	"artist": "10",
+	"photo":  "13",
+}
+
+func (c *Client) GetLibraryLeafCount(ctx context.Context, key, libType string) (count int, headers http.Header, err error) {
+	// Prepare request: only ask for the total size, not the items
+	endpoint := fmt.Sprintf("/library/sections/%s/all", key)
+	query := url.Values{
+		"X-Plex-Container-Start": []string{"0"},
+		"X-Plex-Container-Size":  []string{"0"},
+	}
+	if leafType, found := leafTypes[libType]; found {
+		query.Set("type", leafType)
+	}
+	var answerPayload struct {
+		MediaContainer struct {
+			TotalSize int `json:"totalSize"`
+		} `json:"MediaContainer"`
+	}
+	// Execute request
+	if headers, err = c.request(ctx, "GET", endpoint, query, &answerPayload); err != nil {
+		err = fmt.Errorf("failed to execute library leaf count query: %w", err)
+		return
+	}
+	count = answerPayload.MediaContainer.TotalSize
+	return
+}
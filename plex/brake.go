@@ -0,0 +1,284 @@
+package plex
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	plexapi "github.com/hekmon/rcgdip/plex/api"
+)
+
+const (
+	stateQuarantinePrefix = "quarantine_"
+	deletionBrakeWindow   = 15 * time.Minute
+)
+
+type deletionsRecord struct {
+	at    time.Time
+	count int
+}
+
+// applyDeletionBrake quarantines the deletion driven jobs of the libraries having too many deletions within the brake window.
+// The returned batch deletions must be recorded with recordDeletions once the batch jobs are scheduled.
+func (c *Controller) applyDeletionBrake(jobs []*jobElement, libs []plexapi.Library) (allowedJobs []*jobElement, deletions map[string]int, err error) {
+	if c.brakeCount <= 0 && c.brakePercent <= 0 {
+		return jobs, nil, nil
+	}
+	// Count deletions per library, within this batch and the previous ones
+	deletions = make(map[string]int, len(libs))
+	for _, job := range jobs {
+		deletions[job.LibKey] += job.Deletions
+	}
+	now := time.Now()
+	totals := make(map[string]int, len(deletions))
+	c.quarantineAccess.Lock()
+	for libKey, nbDeletions := range deletions {
+		if nbDeletions > 0 {
+			totals[libKey] = c.recentDeletions(libKey, now) + nbDeletions
+		}
+	}
+	c.quarantineAccess.Unlock()
+	// Check thresholds
+	braked := make(map[string]struct{}, len(totals))
+	for _, lib := range libs {
+		nbDeletions := totals[lib.Key]
+		if nbDeletions == 0 {
+			continue
+		}
+		if c.brakeCount > 0 && nbDeletions > c.brakeCount {
+			c.logger.Errorf("[Plex] mass deletion detected in library '%s': %d deleted files within %v (threshold: %d)",
+				lib.Title, nbDeletions, deletionBrakeWindow, c.brakeCount)
+			braked[lib.Key] = struct{}{}
+			continue
+		}
+		if c.brakePercent > 0 {
+			leaves, _, err := c.plex.GetLibraryLeafCount(c.ctx, lib.Key, lib.Type)
+			if err != nil {
+				c.logger.Errorf("[Plex] failed to get the number of items in library '%s', quarantining its %d deletion(s) to be safe: %s",
+					lib.Title, nbDeletions, err)
+				braked[lib.Key] = struct{}{}
+				continue
+			}
+			if percent := float64(nbDeletions) * 100 / float64(leaves); leaves == 0 || percent > c.brakePercent {
+				c.logger.Errorf("[Plex] mass deletion detected in library '%s': %d deleted files within %v for %d items (threshold: %.02f%%)",
+					lib.Title, nbDeletions, deletionBrakeWindow, leaves, c.brakePercent)
+				braked[lib.Key] = struct{}{}
+			}
+		}
+	}
+	c.quarantineAccess.Lock()
+	defer c.quarantineAccess.Unlock()
+	if len(braked) > 0 {
+		if allowedJobs, err = c.quarantineDeletions(jobs, braked); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		allowedJobs = jobs
+	}
+	return
+}
+
+// recordDeletions remembers a scheduled batch deletions for the next batches
+func (c *Controller) recordDeletions(deletions map[string]int) {
+	now := time.Now()
+	c.quarantineAccess.Lock()
+	defer c.quarantineAccess.Unlock()
+	for libKey, nbDeletions := range deletions {
+		if nbDeletions > 0 {
+			c.brakeRecords[libKey] = append(c.brakeRecords[libKey], deletionsRecord{
+				at:    now,
+				count: nbDeletions,
+			})
+		}
+	}
+}
+
+// recentDeletions must be called with quarantineAccess locked. It returns the deletions recorded within the brake window for libKey.
+func (c *Controller) recentDeletions(libKey string, now time.Time) (total int) {
+	records := c.brakeRecords[libKey]
+	kept := records[:0]
+	for _, record := range records {
+		if now.Sub(record.at) < deletionBrakeWindow {
+			kept = append(kept, record)
+			total += record.count
+		}
+	}
+	if len(kept) == 0 {
+		delete(c.brakeRecords, libKey)
+	} else {
+		c.brakeRecords[libKey] = kept
+	}
+	return
+}
+
+// quarantineDeletions must be called with quarantineAccess locked. It quarantines the deletion driven jobs of the braked libraries:
+// the ones of this batch and the ones already scheduled by the previous batches.
+func (c *Controller) quarantineDeletions(jobs []*jobElement, braked map[string]struct{}) (allowedJobs []*jobElement, err error) {
+	c.queueAccess.Lock()
+	defer c.queueAccess.Unlock()
+	allowedJobs = make([]*jobElement, 0, len(jobs))
+	quarantined := make([]*jobElement, 0, len(jobs))
+	var pending []*jobElement
+	for _, job := range c.queue {
+		if _, found := braked[job.LibKey]; found && job.Deletions > 0 {
+			pending = append(pending, job)
+		}
+	}
+	for _, job := range jobs {
+		if _, found := braked[job.LibKey]; !found || job.Deletions == 0 {
+			allowedJobs = append(allowedJobs, job)
+			continue
+		}
+		pending = append(pending, job)
+	}
+	for _, job := range pending {
+		if err = c.quarantineJob(job); err != nil {
+			err = fmt.Errorf("failed to quarantine the scan of '%s' in '%s': %w", job.ScanPath, job.LibName, err)
+			// the whole batch will be processed again: do not keep a partial quarantine
+			c.unquarantineJobs(quarantined)
+			return nil, err
+		}
+		quarantined = append(quarantined, job)
+	}
+	if err = c.state.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync the quarantined scan jobs: %w", err)
+		c.unquarantineJobs(quarantined)
+		return nil, err
+	}
+	// Quarantine is saved: the scheduled ones can leave the queue
+	for _, job := range quarantined {
+		if c.queued(job) {
+			c.removeJob(job)
+			c.forgetJob(job)
+		}
+		c.trackQuarantine(job)
+		c.logger.Warningf("[Plex] scan of '%s' in '%s' (%d deletion(s)) has been quarantined: it will not be launched until approved",
+			job.ScanPath, job.LibName, job.Deletions)
+	}
+	return
+}
+
+// holdForQuarantine quarantines the job if its scan would also scan quarantined paths
+func (c *Controller) holdForQuarantine(job *jobElement) (held bool) {
+	c.quarantineAccess.Lock()
+	defer c.quarantineAccess.Unlock()
+	if trie := c.quarantined[job.LibKey]; trie == nil || !trie.within(job.ScanPath) {
+		return false
+	}
+	err := c.quarantineJob(job)
+	if err == nil {
+		err = c.state.Sync()
+	}
+	if err != nil {
+		c.logger.Errorf("[Plex] scan of '%s' in '%s' would also scan quarantined paths but it can not be quarantined, retrying in %v: %s",
+			job.ScanPath, job.LibName, scanDeferDelay, err)
+		c.requeueJob(job, time.Now().Add(scanDeferDelay))
+		return true
+	}
+	c.forgetJob(job)
+	c.trackQuarantine(job)
+	c.logger.Warningf("[Plex] scan of '%s' in '%s' would also scan quarantined paths: it has been quarantined too", job.ScanPath, job.LibName)
+	return true
+}
+
+// trackQuarantine must be called with quarantineAccess locked
+func (c *Controller) trackQuarantine(job *jobElement) {
+	trie := c.quarantined[job.LibKey]
+	if trie == nil {
+		trie = newPathTrie()
+		c.quarantined[job.LibKey] = trie
+	}
+	trie.insert(job.ScanPath, 0)
+}
+
+func (c *Controller) restoreQuarantine() {
+	c.quarantineAccess.Lock()
+	defer c.quarantineAccess.Unlock()
+	quarantined := c.quarantinedJobs()
+	for _, job := range quarantined {
+		c.trackQuarantine(job)
+	}
+	if len(quarantined) > 0 {
+		c.logger.Warningf("[Plex] %d scan job(s) are quarantined by the mass deletion brake and waiting for approval", len(quarantined))
+	}
+}
+
+func (c *Controller) quarantineJob(job *jobElement) (err error) {
+	if err = job.assignID(); err != nil {
+		return
+	}
+	if err = c.state.Set(stateQuarantinePrefix+job.ID, job); err != nil {
+		err = fmt.Errorf("failed to save job '%s' within the quarantine: %w", job.ID, err)
+	}
+	return
+}
+
+func (c *Controller) unquarantineJobs(jobs []*jobElement) {
+	for _, job := range jobs {
+		if err := c.state.Delete(stateQuarantinePrefix + job.ID); err != nil {
+			c.logger.Errorf("[Plex] failed to remove job '%s' from the quarantine: %s", job.ID, err)
+		}
+	}
+}
+
+func (c *Controller) quarantinedJobs() (jobs []*jobElement) {
+	var (
+		job   *jobElement
+		found bool
+		err   error
+	)
+	for _, key := range c.state.Keys() {
+		if !strings.HasPrefix(key, stateQuarantinePrefix) {
+			continue
+		}
+		job = nil
+		if found, err = c.state.Get(key, &job); err != nil || !found || job == nil {
+			c.logger.Errorf("[Plex] failed to load the quarantined job '%s' (found: %v): %v", key, found, err)
+			continue
+		}
+		job.ID = key[len(stateQuarantinePrefix):]
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// QuarantinedJobs returns the scan jobs held by the mass deletion brake
+func (c *Controller) QuarantinedJobs() (jobs []ScheduledJob) {
+	quarantined := c.quarantinedJobs()
+	jobs = make([]ScheduledJob, len(quarantined))
+	for index, job := range quarantined {
+		jobs[index] = ScheduledJob{
+			LibKey:   job.LibKey,
+			LibName:  job.LibName,
+			ScanAt:   job.ScanAt,
+			ScanPath: job.ScanPath,
+		}
+	}
+	return
+}
+
+// ApproveQuarantine releases all the scan jobs held by the mass deletion brake to the scheduler
+func (c *Controller) ApproveQuarantine() (approved int) {
+	c.quarantineAccess.Lock()
+	defer c.quarantineAccess.Unlock()
+	quarantined := c.quarantinedJobs()
+	c.quarantined = make(map[string]*pathTrie)
+	for _, job := range quarantined {
+		if err := c.state.Delete(stateQuarantinePrefix + job.ID); err != nil {
+			c.logger.Errorf("[Plex] failed to remove job '%s' from the quarantine: %s", job.ID, err)
+			c.trackQuarantine(job)
+			continue
+		}
+		quarantined[approved] = job
+		approved++
+		c.logger.Noticef("[Plex] quarantined scan of '%s' in '%s' approved", job.ScanPath, job.LibName)
+	}
+	// The approved deletions must not brake the next ones
+	c.brakeRecords = make(map[string][]deletionsRecord)
+	if approved > 0 {
+		if err := c.scheduleJobs(quarantined[:approved]); err != nil {
+			c.logger.Errorf("[Plex] approved scans might be lost if we stop before their execution: %s", err)
+		}
+	}
+	return
+}
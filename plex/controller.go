@@ -4,22 +4,40 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"path"
 	"sync"
 	"time"
 
 	"github.com/hekmon/rcgdip/drivechange"
 	plexapi "github.com/hekmon/rcgdip/plex/api"
+	"github.com/hekmon/rcgdip/rclonerc"
 
 	"github.com/hekmon/hllogger/v2"
+	"golang.org/x/time/rate"
 )
 
 type Config struct {
 	// Global config
-	Input        <-chan []drivechange.File
+	Input        Queue
 	PollInterval time.Duration
 	DirCacheTime time.Duration
-	MountPoint   string
+	MountPoint   string      // used when MountRoots is empty: the whole remote is mounted there
+	MountRoots   []MountRoot // for remotes mounted as several sub paths
+	VerifyMount  bool        // check the changes are visible on the mount before scanning
+	MountGuard   bool        // hold scans while the mount is down or empty
+	MountCanary  string      // optional path (relative to the mount point) which must always be readable
+	PathMappings []PathMapping
+	Libraries    LibrarySelection
+	// Mass deletion brake
+	DeletionBrakeCount   int     // 0 means disabled
+	DeletionBrakePercent float64 // 0 means disabled
+	// Scans throttling
+	MaxConcurrentScans int // 0 means unlimited
+	ScansPerMinute     int // 0 means unlimited
+	// RClone remote control API config (optional)
+	RCloneRCURL  *url.URL
+	RCloneRCUser string
+	RCloneRCPass string
+	RCloneRCFS   string
 	// Plex API config
 	PlexURL        *url.URL
 	PlexToken      string
@@ -42,20 +60,51 @@ type Storage interface {
 	Sync() error
 }
 
+type Queue interface {
+	Next(context.Context) (uint64, []drivechange.File, error)
+	Ack(uint64) error
+}
+
 type Controller struct {
 	// Global
 	ctx        context.Context
 	interval   time.Duration
 	dircache   time.Duration
-	mountPoint string
-	tz         *time.Location
+	optsSynced time.Time // last time interval and dircache have been read from the rclone mount
+	mountRoots []MountRoot
+	verify     bool
+	// Plex path mapping
+	pathMappings []PathMapping
+	// Libraries selection
+	libSelection LibrarySelection
+	libraries    librariesCache
+	// Mount health
+	mountGuard     bool
+	mountCanary    string
+	mountDownSince map[string]time.Time
+	tz             *time.Location
 	// Storage
-	state      Storage
-	jobs       []*jobElement
-	jobsAccess sync.Mutex
+	state       Storage
+	queue       jobsQueue
+	queueAccess sync.Mutex
+	pending     map[string]*jobsTrie // per library index of the queue jobs by scan path
+	queueUpdate chan struct{}
+	unpersisted *queuedBatch // batch whose jobs are queued but not persisted yet: only their persistence is retried
+	// Mass deletion brake
+	brakeCount       int
+	brakePercent     float64
+	brakeRecords     map[string][]deletionsRecord // per library deletions within the brake window
+	quarantined      map[string]*pathTrie         // per library quarantined scan paths
+	quarantineAccess sync.Mutex                   // to be locked before queueAccess if both are needed
+	// Scans throttling
+	maxScans    int
+	scanLimiter *rate.Limiter
 	// Controllers
 	logger *hllogger.Logger
 	plex   *plexapi.Client
+	rclone *rclonerc.Client
+	// rclone remote control clients targeting the VFS of each mount root
+	rcloneVFS map[string]*rclonerc.Client
 	// Workers control plane
 	workers  sync.WaitGroup
 	fullStop chan struct{}
@@ -69,21 +118,35 @@ func New(ctx context.Context, conf Config) (c *Controller, err error) {
 	}()
 	// Base init
 	c = &Controller{
-		ctx:        ctx,
-		interval:   conf.PollInterval,
-		dircache:   conf.DirCacheTime,
-		mountPoint: path.Clean(conf.MountPoint),
-		state:      conf.StateBackend,
-		logger:     conf.Logger,
+		ctx:            ctx,
+		interval:       conf.PollInterval,
+		dircache:       conf.DirCacheTime,
+		verify:         conf.VerifyMount,
+		mountGuard:     conf.MountGuard,
+		mountCanary:    conf.MountCanary,
+		libSelection:   conf.Libraries,
+		mountDownSince: make(map[string]time.Time),
+		state:          conf.StateBackend,
+		queueUpdate:    make(chan struct{}, 1),
+		brakeCount:     conf.DeletionBrakeCount,
+		brakePercent:   conf.DeletionBrakePercent,
+		pending:        make(map[string]*jobsTrie),
+		brakeRecords:   make(map[string][]deletionsRecord),
+		quarantined:    make(map[string]*pathTrie),
+		maxScans:       conf.MaxConcurrentScans,
+		logger:         conf.Logger,
+	}
+	if conf.ScansPerMinute > 0 {
+		c.scanLimiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(conf.ScansPerMinute)), 1)
+	}
+	// Process mount roots
+	if err = c.initMountRoots(conf); err != nil {
+		return
 	}
-	// Process mount point
-	if !path.IsAbs(c.mountPoint) {
-		err = fmt.Errorf("mount point path should be absolute: %s", c.mountPoint)
+	// Process path mappings
+	if err = c.initPathMappings(conf.PathMappings); err != nil {
 		return
 	}
-	// if c.mountPoint[len(c.mountPoint)-1] != '/' {
-	// 	c.mountPoint += "/"
-	// }
 	// Recover or generate a clientID
 	clientID, err := c.getClientID()
 	if err != nil {
@@ -101,14 +164,44 @@ func New(ctx context.Context, conf Config) (c *Controller, err error) {
 		err = fmt.Errorf("failed to instanciate the Plex API client: %w", err)
 		return
 	}
+	// Init the rclone rc client
+	if conf.RCloneRCURL != nil {
+		if c.rclone, err = rclonerc.New(rclonerc.Config{
+			BaseURL: conf.RCloneRCURL,
+			User:    conf.RCloneRCUser,
+			Pass:    conf.RCloneRCPass,
+			FS:      conf.RCloneRCFS,
+		}); err != nil {
+			err = fmt.Errorf("failed to instanciate the RClone remote control API client: %w", err)
+			return
+		}
+		c.rcloneVFS = make(map[string]*rclonerc.Client, len(c.mountRoots))
+		for _, root := range c.mountRoots {
+			if root.RCFS == conf.RCloneRCFS {
+				c.rcloneVFS[root.Local] = c.rclone
+				continue
+			}
+			if c.rcloneVFS[root.Local], err = rclonerc.New(rclonerc.Config{
+				BaseURL: conf.RCloneRCURL,
+				User:    conf.RCloneRCUser,
+				Pass:    conf.RCloneRCPass,
+				FS:      root.RCFS,
+			}); err != nil {
+				err = fmt.Errorf("failed to instanciate the RClone remote control API client for mount root '%s': %w", root.Local, err)
+				return
+			}
+		}
+	}
 	// Get time location
 	c.tz = time.Now().Location()
 	// Restore jobs if needed
 	c.restoreJobs()
+	c.restoreQuarantine()
 	// Workers
 	c.fullStop = make(chan struct{})
 	go c.stopper()
-	c.workers.Add(1)
+	c.workers.Add(2)
+	go c.scheduler()
 	go c.triggerWorker(conf.Input)
 	return
 }
@@ -119,9 +212,6 @@ func (c *Controller) stopper() {
 	// Wait for workers to correctly stop
 	c.logger.Debug("[Plex] waiting for all workers to stop...")
 	c.workers.Wait()
-	// Save unstarted jobs if any
-	c.logger.Debug("[Plex] saving unstarted jobs to state...")
-	c.saveJobs()
 	// Mark full stop
 	close(c.fullStop)
 	c.logger.Info("[Plex] fully stopped")
@@ -0,0 +1,100 @@
+package plex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	mountHealthRetryDelay = 30 * time.Second
+	mountCheckTimeout     = 10 * time.Second
+)
+
+// mountHealthy checks (if enabled) that the mount point containing localPath is really mounted and serving content, logging health transitions
+func (c *Controller) mountHealthy(localPath string) bool {
+	if !c.mountGuard {
+		return true
+	}
+	root, _, ok := c.mountRootOf(localPath)
+	if !ok {
+		return true
+	}
+	downSince, down := c.mountDownSince[root.Local]
+	if err := c.mountCheck(func() error { return c.checkMount(root.Local) }); err != nil {
+		if !down {
+			c.mountDownSince[root.Local] = time.Now()
+			c.logger.Errorf("[Plex] rclone mount '%s' is unhealthy, holding its scans until it recovers: %s", root.Local, err)
+		} else {
+			c.logger.Debugf("[Plex] rclone mount '%s' is still unhealthy (since %v): %s", root.Local, time.Since(downSince), err)
+		}
+		return false
+	}
+	if down {
+		c.logger.Noticef("[Plex] rclone mount '%s' has recovered after %v: releasing held scans", root.Local, time.Since(downSince))
+		delete(c.mountDownSince, root.Local)
+	}
+	return true
+}
+
+// mountCheck runs a blocking check against the mount in its own goroutine: a hung mount must not block the scheduler
+func (c *Controller) mountCheck(check func() error) (err error) {
+	result := make(chan error, 1)
+	go func() {
+		result <- check()
+	}()
+	timer := time.NewTimer(mountCheckTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-result:
+	case <-timer.C:
+		err = fmt.Errorf("no answer from the mount after %v", mountCheckTimeout)
+	case <-c.ctx.Done():
+		err = c.ctx.Err()
+	}
+	return
+}
+
+func (c *Controller) checkMount(mountPoint string) (err error) {
+	// Is it a mount point ? (its device must differ from its parent's one)
+	mountDevice, supported, err := deviceID(mountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to stat the mount point: %w", err)
+	}
+	if supported {
+		var parentDevice uint64
+		if parentDevice, _, err = deviceID(path.Dir(mountPoint)); err != nil {
+			return fmt.Errorf("failed to stat the mount point parent: %w", err)
+		}
+		if mountDevice == parentDevice {
+			return fmt.Errorf("'%s' is not a mount point", mountPoint)
+		}
+	}
+	// Is the canary (or the mount root if none) readable ?
+	target := mountPoint
+	if c.mountCanary != "" {
+		target = path.Join(mountPoint, c.mountCanary)
+	}
+	fd, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("failed to open '%s': %w", target, err)
+	}
+	defer fd.Close()
+	infos, err := fd.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat '%s': %w", target, err)
+	}
+	if infos.IsDir() {
+		if _, err = fd.Readdirnames(1); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("'%s' is empty", target)
+			}
+			return fmt.Errorf("failed to read directory '%s': %w", target, err)
+		}
+	} else if _, err = fd.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read file '%s': %w", target, err)
+	}
+	return nil
+}
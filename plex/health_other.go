@@ -0,0 +1,9 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package plex
+
+// deviceID can not tell the device containing a path on this platform: mount points are only checked for content
+func deviceID(p string) (id uint64, supported bool, err error) {
+	return
+}
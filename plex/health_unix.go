@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package plex
+
+import "syscall"
+
+// deviceID returns the ID of the device containing p
+func deviceID(p string) (id uint64, supported bool, err error) {
+	var stat syscall.Stat_t
+	if err = syscall.Stat(p, &stat); err != nil {
+		return
+	}
+	return uint64(stat.Dev), true, nil
+}
@@ -6,33 +6,46 @@ import (
 	"time"
 
 	plexapi "github.com/hekmon/rcgdip/plex/api"
+
+	uuid "github.com/nu7hatch/gouuid"
 )
 
 const (
-	stateJobsTotalKey = "jobs_len"
-	stateJobsPrefix   = "jobs_#"
+	stateJobPrefix          = "job_"
+	stateLegacyJobsTotalKey = "jobs_len"
+	stateLegacyJobsPrefix   = "jobs_#"
 )
 
 type jobElement struct {
+	ID       string `json:"-"`
 	LibKey   string
 	LibName  string
 	ScanAt   time.Time
 	ScanPath string
+	// Mount verification
+	Expect         []pathExpectation
+	Deadline       time.Time
+	VerifyAttempts int
+	// Mass deletion brake
+	Deletions int
+	// Plex busy handling
+	DeferredSince time.Time
+	index         int  // position within the scheduler queue
+	forgotten     bool // executed or merged into another job: must not be persisted anymore
 }
 
-func (c *Controller) generateJobsDefinition(path string, scanAt time.Time, libs []plexapi.Library) (jobs []*jobElement) {
+func (c *Controller) generateJobsDefinition(path string, target *scanTarget, libs []plexapi.Library, locations *pathTrie) (jobs []*jobElement) {
 	// Find libraries that contains this path
-	validLibs := make(map[string]string, len(libs))
-libs:
-	for _, lib := range libs {
-		for _, location := range lib.Locations {
-			if strings.HasPrefix(path, location) {
-				validLibs[lib.Key] = lib.Title
-				c.logger.Infof("[Plex] library '%s' has a location containing '%s' which needs (re)scan: adding to job creation list",
-					lib.Title, path)
-				continue libs
-			}
+	validLibs := make(map[string]int, len(libs))
+	plexPath := c.plexPath(path)
+	for _, libIndex := range locations.ancestors(plexPath) {
+		lib := libs[libIndex]
+		if _, found := validLibs[lib.Key]; found {
+			continue
 		}
+		validLibs[lib.Key] = libIndex
+		c.logger.Infof("[Plex] library '%s' has a location containing '%s' which needs (re)scan: adding to job creation list",
+			lib.Title, plexPath)
 	}
 	if len(validLibs) == 0 {
 		return
@@ -40,117 +53,171 @@ libs:
 	// Create the jobs definitions
 	jobs = make([]*jobElement, len(validLibs))
 	index := 0
-	for libKey, libName := range validLibs {
+	for libKey, libIndex := range validLibs {
 		jobs[index] = &jobElement{
-			LibKey:   libKey,
-			LibName:  libName,
-			ScanAt:   scanAt,
-			ScanPath: path,
+			LibKey:    libKey,
+			LibName:   libs[libIndex].Title,
+			ScanAt:    target.ScanAt,
+			ScanPath:  path,
+			Expect:    append([]pathExpectation(nil), target.Expect...),
+			Deletions: target.Deletions,
+		}
+		// Files moved away are deleted for this library if they have not been moved within it
+		for _, move := range target.Moves {
+			if !c.withinLibrary(move.To, libIndex, locations) {
+				jobs[index].Deletions += move.Files
+			}
 		}
 		index++
 	}
 	return
 }
 
-func (c *Controller) jobExecutor(job *jobElement) {
-	// A job executor is a goroutine started by the worker
-	defer c.workers.Done()
-	// Compute how long we should wait for the poll interval of the rclone mount point to be matched for sure
-	timer := time.NewTimer(time.Until(job.ScanAt))
-	select {
-	case <-timer.C:
-		// Time's up let's scan this lib/path
-		if _, err := c.plex.ScanLibrary(c.ctx, job.LibKey, job.ScanPath); err != nil {
-			c.logger.Errorf("[Plex] failed to start targeted library scan for '%s' on path '%s': %s", job.LibName, job.ScanPath, err)
-		} else {
-			c.logger.Noticef("[Plex] successfully launched a targeted scan for '%s' on path '%s'", job.LibName, job.ScanPath)
+func (c *Controller) withinLibrary(localPaths []string, libIndex int, locations *pathTrie) bool {
+	for _, localPath := range localPaths {
+		for _, candidate := range locations.ancestors(c.plexPath(localPath)) {
+			if candidate == libIndex {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// absorb merges the mount verification and deletion data of other (being merged into job)
+func (job *jobElement) absorb(other *jobElement, override bool) {
+	job.Deletions += other.Deletions
+	if other.Deadline.After(job.Deadline) {
+		job.Deadline = other.Deadline
+	}
+expectations:
+	for _, otherExpect := range other.Expect {
+		for index, expect := range job.Expect {
+			if expect.Path == otherExpect.Path {
+				if override {
+					job.Expect[index] = otherExpect
+				}
+				continue expectations
+			}
 		}
-	case <-c.ctx.Done():
-		// we should stop, let's save the job in our state
-		timer.Stop()
-		c.logger.Infof("[Plex] scan job for '%s' on '%s' is not yet launched, saving for resume later...", job.LibName, job.ScanPath)
-		c.jobsAccess.Lock()
-		c.jobs = append(c.jobs, job)
-		c.jobsAccess.Unlock()
+		if len(job.Expect) < maxExpectationsPerJob {
+			job.Expect = append(job.Expect, otherExpect)
+		}
+	}
+}
+
+func (job *jobElement) assignID() (err error) {
+	// Assign a stable ID to the job if it does not have one yet
+	if job.ID != "" {
+		return
+	}
+	jobID, err := uuid.NewV4()
+	if err != nil {
+		err = fmt.Errorf("failed to generate a job ID: %w", err)
+		return
+	}
+	job.ID = jobID.String()
+	return
+}
+
+func (c *Controller) persistJob(job *jobElement) (err error) {
+	if err = job.assignID(); err != nil {
+		return
+	}
+	// Save it
+	if err = c.state.Set(stateJobPrefix+job.ID, job); err != nil {
+		err = fmt.Errorf("failed to save job '%s' within the state: %w", job.ID, err)
+	}
+	return
+}
+
+func (c *Controller) forgetJob(job *jobElement) {
+	job.forgotten = true
+	if job.ID == "" {
+		return
+	}
+	if err := c.state.Delete(stateJobPrefix + job.ID); err != nil {
+		c.logger.Errorf("[Plex] failed to delete the completed job '%s' from the state, it will be replayed at next start: %s", job.ID, err)
 	}
 }
 
 func (c *Controller) restoreJobs() {
 	var (
-		err               error
-		found             bool
-		totalJobsSaved    int
-		totalJobsRestored int
-		jobKey            string
-		restoredJob       *jobElement
+		err         error
+		found       bool
+		restoredJob *jobElement
+	)
+	c.queueAccess.Lock()
+	defer c.queueAccess.Unlock()
+	// Restore each job
+	for _, key := range c.state.Keys() {
+		if !strings.HasPrefix(key, stateJobPrefix) {
+			continue
+		}
+		restoredJob = nil
+		if found, err = c.state.Get(key, &restoredJob); err != nil {
+			c.logger.Errorf("[Plex] failed to restore the job '%s': %s", key, err)
+			continue
+		}
+		if !found || restoredJob == nil {
+			c.logger.Errorf("[Plex] failed to restore the job '%s': not found within db (is db inconsistent ?)", key)
+			continue
+		}
+		restoredJob.ID = key[len(stateJobPrefix):]
+		c.pushJob(restoredJob)
+	}
+	// Restore jobs saved by previous versions
+	c.restoreLegacyJobs()
+	// Done
+	if len(c.queue) > 0 {
+		c.logger.Infof("[Plex] restored %d previously planned scan job(s)", len(c.queue))
+	} else {
+		c.logger.Debug("[Plex] no previously planned scan job(s) found/restored")
+	}
+}
+
+func (c *Controller) restoreLegacyJobs() {
+	var (
+		err            error
+		found          bool
+		totalJobsSaved int
+		jobKey         string
+		restoredJob    *jobElement
 	)
 	// Get number of saved jobs
-	if found, err = c.state.Get(stateJobsTotalKey, &totalJobsSaved); err != nil {
-		c.logger.Errorf("[Plex] failed to load the total number of saved job(s), the db might have become inconsistent: %s",
+	if found, err = c.state.Get(stateLegacyJobsTotalKey, &totalJobsSaved); err != nil {
+		c.logger.Errorf("[Plex] failed to load the total number of legacy saved job(s), the db might have become inconsistent: %s",
 			err)
 		return
 	}
 	if !found {
-		c.logger.Debugf("[Plex] saved jobs index not found in db, assuming no job need resuming")
 		return
 	}
-	c.jobs = make([]*jobElement, 0, totalJobsSaved)
-	// Restore each job
-	defer c.jobsAccess.Unlock()
-	c.jobsAccess.Lock()
+	// Restore each job and save it with the new scheme
 	for i := 0; i < totalJobsSaved; i++ {
-		jobKey = jobsGenerateKey(i)
+		jobKey = fmt.Sprintf("%s%d", stateLegacyJobsPrefix, i)
 		// Get the job
+		restoredJob = nil
 		if found, err = c.state.Get(jobKey, &restoredJob); err != nil {
-			c.logger.Errorf("[Plex] failed to restore the job #%d: %s", i, err)
+			c.logger.Errorf("[Plex] failed to restore the legacy job #%d: %s", i, err)
 			continue
 		}
-		if !found {
-			c.logger.Errorf("[Plex] failed to restore the job #%d: not found within db (is db inconsistent ?)", i)
+		if !found || restoredJob == nil {
+			c.logger.Errorf("[Plex] failed to restore the legacy job #%d: not found within db (is db inconsistent ?)", i)
 			continue
 		}
-		// Add it to the list of restored jobs
-		c.jobs = append(c.jobs, restoredJob)
-		totalJobsRestored++
+		if err = c.persistJob(restoredJob); err != nil {
+			c.logger.Errorf("[Plex] failed to migrate the legacy job #%d: %s", i, err)
+			continue
+		}
+		c.pushJob(restoredJob)
 		// Remove it from the db
 		if err = c.state.Delete(jobKey); err != nil {
-			c.logger.Errorf("[Plex] failed to delete within the db the restored job #%d, the db might have become inconsistent: %s", i, err)
+			c.logger.Errorf("[Plex] failed to delete within the db the restored legacy job #%d, the db might have become inconsistent: %s", i, err)
 		}
 	}
 	// Clean the number of saved jobs from db
-	if err = c.state.Delete(stateJobsTotalKey); err != nil {
-		c.logger.Errorf("[Plex] failed to delete total number of saved jobs within the db, it might have become inconsistent: %s", err)
-	}
-	// Done
-	if totalJobsRestored > 0 {
-		c.logger.Infof("[Plex] restored %d previously planned scan job(s)", totalJobsRestored)
-	} else {
-		c.logger.Debug("[Plex] no previously planned scan job(s) found/restored")
-	}
-}
-
-func (c *Controller) saveJobs() {
-	var (
-		err          error
-		totalWritten int
-	)
-	// Save all jobs
-	c.jobsAccess.Lock()
-	for index, job := range c.jobs {
-		if err = c.state.Set(jobsGenerateKey(totalWritten), job); err != nil {
-			c.logger.Errorf("[Plex] failed to save the unstarted job #%d, job will be lost: %s @ %s", index, job.LibName, job.ScanPath)
-		} else {
-			totalWritten++
-		}
+	if err = c.state.Delete(stateLegacyJobsTotalKey); err != nil {
+		c.logger.Errorf("[Plex] failed to delete total number of legacy saved jobs within the db, it might have become inconsistent: %s", err)
 	}
-	c.jobsAccess.Unlock()
-	// Save total
-	if err = c.state.Set(stateJobsTotalKey, totalWritten); err != nil {
-		c.logger.Errorf("[Plex] failed to save the total number of saved job(s), the db might have become inconsistent: %s",
-			err)
-	}
-}
-
-func jobsGenerateKey(jobIndex int) string {
-	return fmt.Sprintf("%s%d", stateJobsPrefix, jobIndex)
 }
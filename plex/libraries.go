@@ -0,0 +1,164 @@
+package plex
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	plexapi "github.com/hekmon/rcgdip/plex/api"
+)
+
+const (
+	librariesCacheTTL        = 10 * time.Minute
+	librariesMinRefreshDelay = time.Minute
+)
+
+// librariesCache is only accessed by the trigger worker
+type librariesCache struct {
+	all       []plexapi.Library
+	selected  []plexapi.Library
+	locations *pathTrie // selected libraries indexed by their locations
+	updatedAt time.Time
+}
+
+// getLibraries returns the selected libraries from the cache, refreshing it if too old. If Plex can not be reached, the last good listing is used.
+func (c *Controller) getLibraries() (libs []plexapi.Library, locations *pathTrie, err error) {
+	if time.Since(c.libraries.updatedAt) >= librariesCacheTTL {
+		if err = c.refreshLibraries(); err != nil {
+			if c.libraries.updatedAt.IsZero() {
+				return
+			}
+			c.logger.Warningf("[Plex] %s: using the libraries listing from %v", err, c.libraries.updatedAt)
+			err = nil
+		}
+	}
+	return c.libraries.selected, c.libraries.locations, nil
+}
+
+// refreshLibraries fetches the libraries listing from Plex, logging the differences with the cached one
+func (c *Controller) refreshLibraries() (err error) {
+	libs, _, err := c.plex.GetLibraries(c.ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to query the current libraries: %w", err)
+		return
+	}
+	changed := c.libraries.updatedAt.IsZero() || c.logLibrariesChanges(c.libraries.all, libs)
+	c.libraries.all = libs
+	c.libraries.selected = c.selectLibraries(libs)
+	c.libraries.locations = newLocationsTrie(c.libraries.selected)
+	c.libraries.updatedAt = time.Now()
+	if changed {
+		c.checkLibraries()
+	}
+	return
+}
+
+func (c *Controller) logLibrariesChanges(previous, current []plexapi.Library) (changed bool) {
+	previousByKey := make(map[string]plexapi.Library, len(previous))
+	for _, lib := range previous {
+		previousByKey[lib.Key] = lib
+	}
+	var previousLocations, currentLocations string
+	for _, lib := range current {
+		old, found := previousByKey[lib.Key]
+		if !found {
+			c.logger.Noticef("[Plex] library '%s' (key: %s) has been added with location(s): %s", lib.Title, lib.Key, joinedLocations(lib))
+			changed = true
+			continue
+		}
+		delete(previousByKey, lib.Key)
+		if old.Title != lib.Title {
+			c.logger.Noticef("[Plex] library '%s' (key: %s) has been renamed to '%s'", old.Title, lib.Key, lib.Title)
+			changed = true
+		}
+		if previousLocations, currentLocations = joinedLocations(old), joinedLocations(lib); previousLocations != currentLocations {
+			c.logger.Noticef("[Plex] library '%s' (key: %s) locations have changed from %s to %s", lib.Title, lib.Key, previousLocations, currentLocations)
+			changed = true
+		}
+	}
+	for _, lib := range previousByKey {
+		c.logger.Noticef("[Plex] library '%s' (key: %s) has been removed", lib.Title, lib.Key)
+		changed = true
+	}
+	return
+}
+
+// newLocationsTrie indexes the libraries (by their index within libs) by their locations
+func newLocationsTrie(libs []plexapi.Library) (locations *pathTrie) {
+	locations = newPathTrie()
+	for index, lib := range libs {
+		for _, location := range lib.Locations {
+			locations.insert(location, index)
+		}
+	}
+	return
+}
+
+func joinedLocations(lib plexapi.Library) string {
+	locations := make([]string, 0, len(lib.Locations))
+	for _, location := range lib.Locations {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	return "'" + strings.Join(locations, "', '") + "'"
+}
+
+// checkLibraries logs how the cached libraries will be used
+func (c *Controller) checkLibraries() {
+	libs := c.libraries.all
+	// Apply the user selection
+	selected := c.libraries.selected
+	if len(selected) != len(libs) {
+		c.logger.Infof("[Plex] %d on %d libraries selected for scans", len(selected), len(libs))
+	}
+	for _, lib := range libs {
+		if c.libSelection.selects(lib) {
+			c.logger.Infof("[Plex] library '%s' (key: %s, type: %s, uuid: %s) is selected for scans", lib.Title, lib.Key, lib.Type, lib.UUID)
+		} else {
+			c.logger.Infof("[Plex] library '%s' (key: %s, type: %s, uuid: %s) is excluded from scans", lib.Title, lib.Key, lib.Type, lib.UUID)
+		}
+	}
+	libs = selected
+	// Check libs locations
+	var (
+		nbPaths       int
+		nbCandidates  int
+		localLocation string
+	)
+	mappingsMatches := make([]int, len(c.pathMappings))
+	for _, lib := range libs {
+		nbPaths += len(lib.Locations)
+		for _, location := range lib.Locations {
+			// Get back the location as we see it
+			localLocation = location
+			for index, mapping := range c.pathMappings {
+				if rel, ok := trimPathPrefix(location, mapping.Plex); ok {
+					mappingsMatches[index]++
+					localLocation = path.Join(mapping.Local, rel)
+					break
+				}
+			}
+			if _, _, ok := c.mountRootOf(localLocation); ok {
+				nbCandidates++
+			}
+		}
+	}
+	for index, mapping := range c.pathMappings {
+		if mappingsMatches[index] == 0 {
+			c.logger.Warningf("[Plex] path mapping '%s' -> '%s' does not match any library location", mapping.Local, mapping.Plex)
+		} else {
+			c.logger.Infof("[Plex] path mapping '%s' -> '%s' matches %d library location(s)", mapping.Local, mapping.Plex, mappingsMatches[index])
+		}
+	}
+	if nbPaths == 0 {
+		c.logger.Warning("[Plex] no location found in any library: change events won't trigger any scan")
+	} else if nbCandidates == 0 {
+		c.logger.Warningf("[Plex] found %d libraries based on %d locations but none are based on rclone mount point(s) '%s': change events won't trigger any scan",
+			len(libs), nbPaths, c.mountPoints())
+	} else {
+		c.logger.Infof("[Plex] found %d libraries based on %d locations on which %d are based on declared rclone mountpoint(s) '%s'",
+			len(libs), nbPaths, nbCandidates, c.mountPoints())
+	}
+}
@@ -0,0 +1,57 @@
+package plex
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// PathMapping rewrites a local path prefix into the prefix Plex uses for the same location (eg when Plex runs within a container)
+type PathMapping struct {
+	Local string // prefix as seen by rcgdip
+	Plex  string // prefix as seen by Plex
+}
+
+func (c *Controller) initPathMappings(mappings []PathMapping) (err error) {
+	c.pathMappings = make([]PathMapping, len(mappings))
+	for index, mapping := range mappings {
+		if !path.IsAbs(mapping.Local) || !path.IsAbs(mapping.Plex) {
+			err = fmt.Errorf("path mapping #%d ('%s' -> '%s'): both paths must be absolute", index+1, mapping.Local, mapping.Plex)
+			return
+		}
+		c.pathMappings[index] = PathMapping{
+			Local: path.Clean(mapping.Local),
+			Plex:  path.Clean(mapping.Plex),
+		}
+	}
+	// Most specific rules first
+	sort.SliceStable(c.pathMappings, func(i, j int) bool {
+		return len(c.pathMappings[i].Local) > len(c.pathMappings[j].Local)
+	})
+	return
+}
+
+// plexPath returns the path Plex uses for a local path
+func (c *Controller) plexPath(localPath string) string {
+	for _, mapping := range c.pathMappings {
+		if rel, ok := trimPathPrefix(localPath, mapping.Local); ok {
+			return path.Join(mapping.Plex, rel)
+		}
+	}
+	return localPath
+}
+
+// trimPathPrefix returns what remains of p without prefix if prefix is p or one of its ancestors
+func trimPathPrefix(p, prefix string) (rel string, ok bool) {
+	switch {
+	case p == prefix:
+		return "", true
+	case prefix == "/":
+		return strings.TrimPrefix(p, "/"), true
+	case strings.HasPrefix(p, prefix+"/"):
+		return p[len(prefix)+1:], true
+	default:
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package plex
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MountRoot maps a path within the remote to the local path it is mounted on
+type MountRoot struct {
+	Remote string // path within the remote ("" or "/" for the whole remote)
+	Local  string // local mount point
+	RCFS   string // VFS of this mount within the rclone remote control instance, overrides Config.RCloneRCFS
+}
+
+func (c *Controller) initMountRoots(conf Config) (err error) {
+	roots := conf.MountRoots
+	if len(roots) == 0 {
+		roots = []MountRoot{{Remote: "/", Local: conf.MountPoint}}
+	}
+	c.mountRoots = make([]MountRoot, len(roots))
+	for index, root := range roots {
+		if !path.IsAbs(root.Local) {
+			err = fmt.Errorf("mount point path should be absolute: %s", root.Local)
+			return
+		}
+		c.mountRoots[index] = MountRoot{
+			Remote: path.Join("/", root.Remote),
+			Local:  path.Clean(root.Local),
+			RCFS:   root.RCFS,
+		}
+		if c.mountRoots[index].RCFS == "" {
+			c.mountRoots[index].RCFS = conf.RCloneRCFS
+		}
+	}
+	// Most specific remote paths first
+	sort.SliceStable(c.mountRoots, func(i, j int) bool {
+		return len(c.mountRoots[i].Remote) > len(c.mountRoots[j].Remote)
+	})
+	return
+}
+
+// localPath returns where a remote path can be found locally, using the most specific mount root containing it
+func (c *Controller) localPath(remotePath string) (localPath string, ok bool) {
+	remotePath = path.Join("/", remotePath)
+	var rel string
+	for _, root := range c.mountRoots {
+		if rel, ok = trimPathPrefix(remotePath, root.Remote); ok {
+			return path.Join(root.Local, rel), true
+		}
+	}
+	return
+}
+
+// mountRootOf returns the mount root containing a local path and the path relative to it
+func (c *Controller) mountRootOf(localPath string) (root MountRoot, rel string, ok bool) {
+	for _, root = range c.mountRoots {
+		if rel, ok = trimPathPrefix(localPath, root.Local); ok {
+			return
+		}
+	}
+	return
+}
+
+func (c *Controller) mountPoints() string {
+	locals := make([]string, len(c.mountRoots))
+	for index, root := range c.mountRoots {
+		locals[index] = root.Local
+	}
+	return strings.Join(locals, "', '")
+}
@@ -0,0 +1,161 @@
+package plex
+
+import (
+	"path"
+	"strings"
+)
+
+// pathTrie indexes values (usually slice indexes) by path, one node per cleaned path component.
+// It allows to find every value registered on a path or one of its ancestors while respecting the components boundaries.
+type pathTrie struct {
+	children map[string]*pathTrie
+	values   []int
+}
+
+func newPathTrie() *pathTrie {
+	return &pathTrie{
+		children: make(map[string]*pathTrie),
+	}
+}
+
+// insert registers value on p
+func (pt *pathTrie) insert(p string, value int) {
+	node := pt
+	for _, component := range pathComponents(p) {
+		child := node.children[component]
+		if child == nil {
+			child = newPathTrie()
+			node.children[component] = child
+		}
+		node = child
+	}
+	node.values = append(node.values, value)
+}
+
+// ancestors returns the values registered on p or any of its ancestors, from the root to p
+func (pt *pathTrie) ancestors(p string) (values []int) {
+	node := pt
+	values = append(values, node.values...)
+	for _, component := range pathComponents(p) {
+		if node = node.children[component]; node == nil {
+			return
+		}
+		values = append(values, node.values...)
+	}
+	return
+}
+
+// within returns true if a value is registered on p or any of its descendants
+func (pt *pathTrie) within(p string) bool {
+	node := pt
+	for _, component := range pathComponents(p) {
+		if node = node.children[component]; node == nil {
+			return false
+		}
+	}
+	return node.hasValues()
+}
+
+func (pt *pathTrie) hasValues() bool {
+	if len(pt.values) > 0 {
+		return true
+	}
+	for _, child := range pt.children {
+		if child.hasValues() {
+			return true
+		}
+	}
+	return false
+}
+
+func pathComponents(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+// jobsTrie indexes the pending jobs of a library by scan path
+type jobsTrie struct {
+	children map[string]*jobsTrie
+	jobs     []*jobElement
+}
+
+func newJobsTrie() *jobsTrie {
+	return &jobsTrie{
+		children: make(map[string]*jobsTrie),
+	}
+}
+
+func (jt *jobsTrie) insert(job *jobElement) {
+	node := jt
+	for _, component := range pathComponents(job.ScanPath) {
+		child := node.children[component]
+		if child == nil {
+			child = newJobsTrie()
+			node.children[component] = child
+		}
+		node = child
+	}
+	node.jobs = append(node.jobs, job)
+}
+
+// remove unregisters job and prunes the nodes left empty
+func (jt *jobsTrie) remove(job *jobElement) {
+	jt.removeComponents(job, pathComponents(job.ScanPath))
+}
+
+func (jt *jobsTrie) removeComponents(job *jobElement, components []string) (empty bool) {
+	if len(components) == 0 {
+		for index, candidate := range jt.jobs {
+			if candidate == job {
+				jt.jobs = append(jt.jobs[:index], jt.jobs[index+1:]...)
+				break
+			}
+		}
+	} else if child := jt.children[components[0]]; child != nil && child.removeComponents(job, components[1:]) {
+		delete(jt.children, components[0])
+	}
+	return jt.empty()
+}
+
+// ancestor returns the first job registered on p or any of its ancestors, starting from the root
+func (jt *jobsTrie) ancestor(p string) *jobElement {
+	node := jt
+	if len(node.jobs) > 0 {
+		return node.jobs[0]
+	}
+	for _, component := range pathComponents(p) {
+		if node = node.children[component]; node == nil {
+			return nil
+		}
+		if len(node.jobs) > 0 {
+			return node.jobs[0]
+		}
+	}
+	return nil
+}
+
+// descendants returns the jobs registered on p or any of its descendants
+func (jt *jobsTrie) descendants(p string) (jobs []*jobElement) {
+	node := jt
+	for _, component := range pathComponents(p) {
+		if node = node.children[component]; node == nil {
+			return
+		}
+	}
+	return node.collect(jobs)
+}
+
+func (jt *jobsTrie) collect(jobs []*jobElement) []*jobElement {
+	jobs = append(jobs, jt.jobs...)
+	for _, child := range jt.children {
+		jobs = child.collect(jobs)
+	}
+	return jobs
+}
+
+func (jt *jobsTrie) empty() bool {
+	return len(jt.jobs) == 0 && len(jt.children) == 0
+}
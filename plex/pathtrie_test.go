@@ -0,0 +1,183 @@
+package plex
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hekmon/hllogger/v2"
+)
+
+func TestPathTrieAncestors(t *testing.T) {
+	locations := []string{
+		"/mnt/Movies",
+		"/mnt/Movies 4K",
+		"/mnt/TV/",
+		"/mnt/TV",
+		"/mnt/Music//Albums",
+	}
+	trie := newPathTrie()
+	for index, location := range locations {
+		trie.insert(location, index)
+	}
+	rootTrie := newPathTrie()
+	rootTrie.insert("/", 0)
+	rootTrie.insert("/mnt/Movies", 1)
+	testCases := []struct {
+		name   string
+		trie   *pathTrie
+		path   string
+		values []int
+	}{
+		{name: "location itself", trie: trie, path: "/mnt/Movies", values: []int{0}},
+		{name: "within location", trie: trie, path: "/mnt/Movies/Movie (2022)/Movie.mkv", values: []int{0}},
+		{name: "component boundary", trie: trie, path: "/mnt/Movies 4K/Movie (2022)", values: []int{1}},
+		{name: "prefix without boundary", trie: trie, path: "/mnt/Movies 4", values: nil},
+		{name: "trailing slash", trie: trie, path: "/mnt/Movies/", values: []int{0}},
+		{name: "duplicate locations", trie: trie, path: "/mnt/TV/Show/Season 1", values: []int{2, 3}},
+		{name: "uncleaned location", trie: trie, path: "/mnt/Music/Albums/Album", values: []int{4}},
+		{name: "uncleaned path", trie: trie, path: "/mnt/./Movies/../TV//Show", values: []int{2, 3}},
+		{name: "parent of locations", trie: trie, path: "/mnt", values: nil},
+		{name: "outside", trie: trie, path: "/srv/Movies", values: nil},
+		{name: "root location", trie: rootTrie, path: "/srv/Movies", values: []int{0}},
+		{name: "root and nested locations", trie: rootTrie, path: "/mnt/Movies/Movie", values: []int{0, 1}},
+		{name: "root path", trie: rootTrie, path: "/", values: []int{0}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if values := tc.trie.ancestors(tc.path); !reflect.DeepEqual(values, tc.values) {
+				t.Errorf("expected %v, got %v", tc.values, values)
+			}
+		})
+	}
+}
+
+func TestPathTrieWithin(t *testing.T) {
+	trie := newPathTrie()
+	trie.insert("/mnt/Movies/Movie (2022)", 0)
+	testCases := []struct {
+		path   string
+		within bool
+	}{
+		{path: "/", within: true},
+		{path: "/mnt/Movies", within: true},
+		{path: "/mnt/Movies/", within: true},
+		{path: "/mnt/Movies/Movie (2022)", within: true},
+		{path: "/mnt/Movies/Movie (2022)/Extras", within: false},
+		{path: "/mnt/Movies/Movie", within: false},
+		{path: "/mnt/Movies 4K", within: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			if within := trie.within(tc.path); within != tc.within {
+				t.Errorf("expected %v, got %v", tc.within, within)
+			}
+		})
+	}
+}
+
+func TestJobsTrie(t *testing.T) {
+	movies := &jobElement{ScanPath: "/mnt/Movies"}
+	movie := &jobElement{ScanPath: "/mnt/Movies/Movie (2022)"}
+	movies4K := &jobElement{ScanPath: "/mnt/Movies 4K/"}
+	trie := newJobsTrie()
+	for _, job := range []*jobElement{movies, movie, movies4K} {
+		trie.insert(job)
+	}
+	testCases := []struct {
+		path        string
+		ancestor    *jobElement
+		descendants []*jobElement
+	}{
+		{path: "/", descendants: []*jobElement{movies, movie, movies4K}},
+		{path: "/mnt/Movies/", ancestor: movies, descendants: []*jobElement{movies, movie}},
+		{path: "/mnt/Movies/Movie (2022)/Movie.mkv", ancestor: movies},
+		{path: "/mnt/Movies 4K/Movie", ancestor: movies4K},
+		{path: "/mnt/Movies 4", descendants: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			if ancestor := trie.ancestor(tc.path); ancestor != tc.ancestor {
+				t.Errorf("ancestor: expected %v, got %v", tc.ancestor, ancestor)
+			}
+			if descendants := sortedPaths(trie.descendants(tc.path)); !reflect.DeepEqual(descendants, sortedPaths(tc.descendants)) {
+				t.Errorf("descendants: expected %v, got %v", sortedPaths(tc.descendants), descendants)
+			}
+		})
+	}
+	// Removal prunes the emptied nodes
+	trie.remove(movie)
+	if descendants := trie.descendants("/mnt/Movies"); len(descendants) != 1 || descendants[0] != movies {
+		t.Errorf("after removal: expected only '/mnt/Movies' to remain below it, got %v", sortedPaths(descendants))
+	}
+	trie.remove(movies)
+	trie.remove(movies4K)
+	if !trie.empty() {
+		t.Errorf("expected the trie to be empty once every job has been removed")
+	}
+}
+
+func sortedPaths(jobs []*jobElement) (paths []string) {
+	for _, job := range jobs {
+		paths = append(paths, job.ScanPath)
+	}
+	sort.Strings(paths)
+	return
+}
+
+func TestMergeWithPending(t *testing.T) {
+	now := time.Now()
+	c := &Controller{
+		logger:  hllogger.New(ioutil.Discard, hllogger.Debug),
+		pending: make(map[string]*jobsTrie),
+	}
+	// Pending jobs
+	movie := &jobElement{LibKey: "1", ScanPath: "/mnt/Movies/Movie (2022)", ScanAt: now.Add(time.Minute), Deletions: 1}
+	movie4K := &jobElement{LibKey: "2", ScanPath: "/mnt/Movies 4K/Movie (2022)", ScanAt: now}
+	other := &jobElement{LibKey: "1", ScanPath: "/mnt/Movies/Other (2021)", ScanAt: now.Add(2 * time.Minute), Deletions: 2}
+	for _, job := range []*jobElement{movie, movie4K, other} {
+		c.pushJob(job)
+	}
+	// A child of a pending job is absorbed by it
+	child := &jobElement{LibKey: "1", ScanPath: "/mnt/Movies/Movie (2022)/Extras", ScanAt: now.Add(3 * time.Minute), Deletions: 3}
+	if merger := c.mergeWithPending(child); merger != movie {
+		t.Fatalf("expected the child to be merged within '%s', got %v", movie.ScanPath, merger)
+	}
+	if !movie.ScanAt.Equal(child.ScanAt) || movie.Deletions != 4 {
+		t.Errorf("expected the pending job to be delayed to %v with 4 deletions, got %v with %d", child.ScanAt, movie.ScanAt, movie.Deletions)
+	}
+	// Same path in another library is not merged
+	if merger := c.mergeWithPending(&jobElement{LibKey: "3", ScanPath: "/mnt/Movies/Movie (2022)"}); merger != nil {
+		t.Errorf("expected no merge for another library, got '%s'", merger.ScanPath)
+	}
+	// A sibling sharing a prefix is not merged
+	if merger := c.mergeWithPending(&jobElement{LibKey: "1", ScanPath: "/mnt/Movies/Movie (2022) Director's Cut"}); merger != nil {
+		t.Errorf("expected no merge for a sibling, got '%s'", merger.ScanPath)
+	}
+	// A parent absorbs the pending jobs it contains, for its library only
+	parent := &jobElement{LibKey: "1", ScanPath: "/mnt/Movies/", ScanAt: now}
+	if merger := c.mergeWithPending(parent); merger != nil {
+		t.Fatalf("expected the parent not to be merged, got '%s'", merger.ScanPath)
+	}
+	if !parent.ScanAt.Equal(child.ScanAt) || parent.Deletions != 6 {
+		t.Errorf("expected the parent to be delayed to %v with 6 deletions, got %v with %d", child.ScanAt, parent.ScanAt, parent.Deletions)
+	}
+	if len(c.queue) != 1 || c.queue[0] != movie4K {
+		t.Fatalf("expected only '%s' to remain within the queue, got %v", movie4K.ScanPath, sortedPaths(c.queue))
+	}
+	if _, found := c.pending["1"]; found {
+		t.Errorf("expected the index of library 1 to be removed once empty")
+	}
+	// The queue and its index stay consistent
+	c.pushJob(parent)
+	for _, expected := range []*jobElement{movie4K, parent} {
+		if job := c.popJob(); job != expected {
+			t.Errorf("expected '%s' to be the next job, got '%s'", expected.ScanPath, job.ScanPath)
+		}
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("expected the index to be empty with the queue, got %d indexed libraries", len(c.pending))
+	}
+}
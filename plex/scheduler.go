@@ -0,0 +1,327 @@
+package plex
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	scanDeferDelay = 30 * time.Second
+	scanMaxDefer   = time.Hour
+)
+
+// jobsQueue is a priority queue of jobs ordered by their scan time, to be used with container/heap
+type jobsQueue []*jobElement
+
+func (jq jobsQueue) Len() int { return len(jq) }
+
+func (jq jobsQueue) Less(i, j int) bool { return jq[i].ScanAt.Before(jq[j].ScanAt) }
+
+func (jq jobsQueue) Swap(i, j int) {
+	jq[i], jq[j] = jq[j], jq[i]
+	jq[i].index = i
+	jq[j].index = j
+}
+
+func (jq *jobsQueue) Push(x interface{}) {
+	job := x.(*jobElement)
+	job.index = len(*jq)
+	*jq = append(*jq, job)
+}
+
+func (jq *jobsQueue) Pop() interface{} {
+	old := *jq
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	job.index = -1
+	*jq = old[:len(old)-1]
+	return job
+}
+
+// pushJob must be called with queueAccess locked
+func (c *Controller) pushJob(job *jobElement) {
+	heap.Push(&c.queue, job)
+	trie := c.pending[job.LibKey]
+	if trie == nil {
+		trie = newJobsTrie()
+		c.pending[job.LibKey] = trie
+	}
+	trie.insert(job)
+}
+
+// popJob must be called with queueAccess locked
+func (c *Controller) popJob() (job *jobElement) {
+	job = heap.Pop(&c.queue).(*jobElement)
+	c.unindexJob(job)
+	return
+}
+
+// removeJob must be called with queueAccess locked
+func (c *Controller) removeJob(job *jobElement) {
+	heap.Remove(&c.queue, job.index)
+	c.unindexJob(job)
+}
+
+func (c *Controller) unindexJob(job *jobElement) {
+	if trie := c.pending[job.LibKey]; trie != nil {
+		if trie.remove(job); trie.empty() {
+			delete(c.pending, job.LibKey)
+		}
+	}
+}
+
+// ScheduledJob is a read only view of a pending scan job
+type ScheduledJob struct {
+	LibKey   string
+	LibName  string
+	ScanAt   time.Time
+	ScanPath string
+}
+
+// ScheduledJobs returns the pending scan jobs ordered by scan time
+func (c *Controller) ScheduledJobs() (jobs []ScheduledJob) {
+	c.queueAccess.Lock()
+	jobs = make([]ScheduledJob, len(c.queue))
+	for index, job := range c.queue {
+		jobs[index] = ScheduledJob{
+			LibKey:   job.LibKey,
+			LibName:  job.LibName,
+			ScanAt:   job.ScanAt,
+			ScanPath: job.ScanPath,
+		}
+	}
+	c.queueAccess.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ScanAt.Before(jobs[j].ScanAt) })
+	return
+}
+
+func (c *Controller) scheduleJobs(jobs []*jobElement) (err error) {
+	// Jobs are persisted while the queue is locked: the scheduler can not execute and forget them meanwhile
+	c.queueAccess.Lock()
+	defer c.queueAccess.Unlock()
+	return c.persistJobs(c.queueJobs(jobs))
+}
+
+// queueJobs must be called with queueAccess locked. It returns the new or updated queue jobs, to be persisted.
+func (c *Controller) queueJobs(jobs []*jobElement) (changed []*jobElement) {
+	changedSet := make(map[*jobElement]struct{}, len(jobs))
+	for _, job := range jobs {
+		if pending := c.mergeWithPending(job); pending != nil {
+			changedSet[pending] = struct{}{}
+			// a requeued or approved job is already persisted
+			c.forgetJob(job)
+			continue
+		}
+		c.pushJob(job)
+		changedSet[job] = struct{}{}
+		c.logger.Debugf("[Plex] scheduling scan of '%s' in '%s' at %v", job.ScanPath, job.LibName, job.ScanAt)
+	}
+	changed = make([]*jobElement, 0, len(changedSet))
+	for job := range changedSet {
+		changed = append(changed, job)
+	}
+	// Wake up the scheduler
+	select {
+	case c.queueUpdate <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// persistJobs must be called with queueAccess locked. It saves the jobs still within the queue to be able to recover them whatever happens.
+func (c *Controller) persistJobs(jobs []*jobElement) (err error) {
+	var failed int
+	for _, job := range jobs {
+		if job.forgotten || !c.queued(job) {
+			// merged within another job or already executed
+			continue
+		}
+		if persistErr := c.persistJob(job); persistErr != nil {
+			c.logger.Errorf("[Plex] failed to persist scan job of '%s' in '%s': %s", job.ScanPath, job.LibName, persistErr)
+			if failed++; err == nil {
+				err = persistErr
+			}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to persist %d scan job(s): %w", failed, err)
+		return
+	}
+	if err = c.state.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync the persisted scan jobs: %w", err)
+	}
+	return
+}
+
+// queued must be called with queueAccess locked
+func (c *Controller) queued(job *jobElement) bool {
+	return job.index >= 0 && job.index < len(c.queue) && c.queue[job.index] == job
+}
+
+// mergeWithPending must be called with queueAccess locked. If job can be handled by a pending job, the pending job is returned.
+func (c *Controller) mergeWithPending(job *jobElement) (merger *jobElement) {
+	trie := c.pending[job.LibKey]
+	if trie == nil {
+		return
+	}
+	// Is there a pending job for the same path or a parent of it ?
+	if pending := trie.ancestor(job.ScanPath); pending != nil {
+		c.logger.Debugf("[Plex] library '%s': path '%s' not scheduled: '%s' is already scheduled for scan",
+			job.LibName, job.ScanPath, pending.ScanPath)
+		pending.absorb(job, true)
+		if job.ScanAt.After(pending.ScanAt) {
+			c.logger.Debugf("[Plex] library '%s': delaying the scheduled scan of '%s' from %v to %v",
+				pending.LibName, pending.ScanPath, pending.ScanAt, job.ScanAt)
+			pending.ScanAt = job.ScanAt
+			heap.Fix(&c.queue, pending.index)
+		}
+		return pending
+	}
+	// Does this job include some pending jobs ?
+	for _, pending := range trie.descendants(job.ScanPath) {
+		c.logger.Debugf("[Plex] library '%s': scheduled scan of '%s' removed: its parent '%s' is being scheduled for scan",
+			pending.LibName, pending.ScanPath, job.ScanPath)
+		if pending.ScanAt.After(job.ScanAt) {
+			job.ScanAt = pending.ScanAt
+		}
+		job.absorb(pending, false)
+		c.removeJob(pending)
+		c.forgetJob(pending)
+	}
+	return
+}
+
+func (c *Controller) scheduler() {
+	defer c.workers.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		// Find out what to do next
+		var (
+			job    *jobElement
+			waitIn time.Duration
+		)
+		c.queueAccess.Lock()
+		if len(c.queue) > 0 {
+			if waitIn = time.Until(c.queue[0].ScanAt); waitIn <= 0 {
+				job = c.popJob()
+			}
+		}
+		c.queueAccess.Unlock()
+		// Execute the due job if plex can take it
+		if job != nil {
+			if c.holdForQuarantine(job) {
+				continue
+			}
+			if !c.mountHealthy(job.ScanPath) {
+				c.requeueJob(job, time.Now().Add(mountHealthRetryDelay))
+				continue
+			}
+			if !c.mountReflects(job) {
+				c.requeueJob(job, c.nextVerification(job))
+				continue
+			}
+			if c.shouldDefer(job) {
+				c.requeueJob(job, time.Now().Add(scanDeferDelay))
+				continue
+			}
+			// Only the scans about to be launched consume the rate limit
+			if c.scanLimiter != nil {
+				if err := c.scanLimiter.Wait(c.ctx); err != nil {
+					c.requeueJob(job, job.ScanAt)
+					continue
+				}
+			}
+			c.executeJob(job)
+			continue
+		}
+		// Wait for the next job or an update of the queue
+		var wakeUp <-chan time.Time
+		if waitIn > 0 {
+			timer.Reset(waitIn)
+			wakeUp = timer.C
+		}
+		select {
+		case <-wakeUp:
+		case <-c.queueUpdate:
+			if wakeUp != nil && !timer.Stop() {
+				<-timer.C
+			}
+		case <-c.ctx.Done():
+			c.queueAccess.Lock()
+			if len(c.queue) > 0 {
+				c.logger.Infof("[Plex] %d scan job(s) not yet launched, they will be resumed later", len(c.queue))
+			}
+			c.queueAccess.Unlock()
+			c.logger.Debug("[Plex] stopping scheduler as main context has been cancelled")
+			return
+		}
+	}
+}
+
+func (c *Controller) executeJob(job *jobElement) {
+	plexPath := c.plexPath(job.ScanPath)
+	if _, err := c.plex.ScanLibrary(c.ctx, job.LibKey, plexPath); err != nil {
+		if c.ctx.Err() != nil {
+			// keep it within the state for next start
+			return
+		}
+		c.logger.Errorf("[Plex] failed to start targeted library scan for '%s' on path '%s': %s", job.LibName, plexPath, err)
+	} else {
+		c.logger.Noticef("[Plex] successfully launched a targeted scan for '%s' on path '%s'", job.LibName, plexPath)
+	}
+	c.forgetJob(job)
+}
+
+func (c *Controller) shouldDefer(job *jobElement) bool {
+	// Get the current libraries status
+	libs, _, err := c.plex.GetLibraries(c.ctx)
+	if err != nil {
+		c.logger.Warningf("[Plex] failed to query the libraries status before scanning, scanning anyway: %s", err)
+		return false
+	}
+	var (
+		nbRefreshing     int
+		targetRefreshing bool
+	)
+	for _, lib := range libs {
+		if lib.Refreshing {
+			nbRefreshing++
+			if lib.Key == job.LibKey {
+				targetRefreshing = true
+			}
+		}
+	}
+	if !targetRefreshing && (c.maxScans <= 0 || nbRefreshing < c.maxScans) {
+		job.DeferredSince = time.Time{}
+		return false
+	}
+	// Do not wait forever on a busy server
+	if job.DeferredSince.IsZero() {
+		job.DeferredSince = time.Now()
+	} else if time.Since(job.DeferredSince) >= scanMaxDefer {
+		c.logger.Warningf("[Plex] scan of '%s' in '%s' has been deferred for %v: scanning anyway", job.ScanPath, job.LibName, scanMaxDefer)
+		return false
+	}
+	if targetRefreshing {
+		c.logger.Infof("[Plex] library '%s' is currently refreshing: deferring the scan of '%s' by %v", job.LibName, job.ScanPath, scanDeferDelay)
+	} else {
+		c.logger.Infof("[Plex] %d libraries are currently refreshing (max %d): deferring the scan of '%s' in '%s' by %v",
+			nbRefreshing, c.maxScans, job.ScanPath, job.LibName, scanDeferDelay)
+	}
+	return true
+}
+
+func (c *Controller) requeueJob(job *jobElement, scanAt time.Time) {
+	if c.ctx.Err() != nil {
+		// job is still within the state for next start
+		return
+	}
+	job.ScanAt = scanAt
+	if err := c.scheduleJobs([]*jobElement{job}); err != nil {
+		c.logger.Errorf("[Plex] scan of '%s' in '%s' might be lost if we stop before its execution: %s", job.ScanPath, job.LibName, err)
+	}
+}
@@ -0,0 +1,41 @@
+package plex
+
+import (
+	"strings"
+
+	plexapi "github.com/hekmon/rcgdip/plex/api"
+)
+
+// LibrarySelection restricts the libraries scans can be scheduled for. Each selector can be a library key, title, type (movie, show, artist, photo) or UUID.
+type LibrarySelection struct {
+	Include []string // if not empty, only the matching libraries are selected
+	Exclude []string // matching libraries are never selected, even if included
+}
+
+func (ls LibrarySelection) selects(lib plexapi.Library) bool {
+	if len(ls.Include) > 0 && !librarySelectorsMatch(lib, ls.Include) {
+		return false
+	}
+	return !librarySelectorsMatch(lib, ls.Exclude)
+}
+
+func librarySelectorsMatch(lib plexapi.Library, selectors []string) bool {
+	for _, selector := range selectors {
+		if selector == lib.Key || selector == lib.UUID ||
+			strings.EqualFold(selector, lib.Title) || strings.EqualFold(selector, lib.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLibraries filters out the libraries not selected by the user
+func (c *Controller) selectLibraries(libs []plexapi.Library) (selected []plexapi.Library) {
+	selected = make([]plexapi.Library, 0, len(libs))
+	for _, lib := range libs {
+		if c.libSelection.selects(lib) {
+			selected = append(selected, lib)
+		}
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package plex
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	maxExpectationsPerJob = 8
+	verifyBaseDelay       = time.Second
+	verifyMaxDelay        = time.Minute
+)
+
+type pathExpectation struct {
+	Path    string // local path on the mount
+	Present bool
+	Folder  bool
+}
+
+// mountReflects returns true if the job changes are visible on the mount (or if we can not/should not wait for them anymore)
+func (c *Controller) mountReflects(job *jobElement) bool {
+	if !c.verify || len(job.Expect) == 0 || job.Deadline.IsZero() {
+		return true
+	}
+	if !time.Now().Before(job.Deadline) {
+		c.logger.Warningf("[Plex] changes in '%s' are still not all visible on the mount after %d check(s) but its deadline has been reached: scanning anyway",
+			job.ScanPath, job.VerifyAttempts)
+		return true
+	}
+	job.VerifyAttempts++
+	for _, expect := range job.Expect {
+		err := c.mountCheck(func() (err error) {
+			_, err = os.Lstat(expect.Path)
+			return
+		})
+		switch {
+		case err == nil && !expect.Present:
+			c.logger.Debugf("[Plex] '%s' is still present on the mount (check #%d)", expect.Path, job.VerifyAttempts)
+			return false
+		case errors.Is(err, os.ErrNotExist) && expect.Present:
+			c.logger.Debugf("[Plex] '%s' is not yet present on the mount (check #%d)", expect.Path, job.VerifyAttempts)
+			return false
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			c.logger.Warningf("[Plex] failed to check '%s' on the mount (check #%d): %s", expect.Path, job.VerifyAttempts, err)
+			return false
+		}
+	}
+	c.logger.Infof("[Plex] changes in '%s' are visible on the mount after %d check(s)", job.ScanPath, job.VerifyAttempts)
+	return true
+}
+
+func (c *Controller) nextVerification(job *jobElement) (next time.Time) {
+	delay := verifyBaseDelay << (job.VerifyAttempts - 1)
+	if delay > verifyMaxDelay || delay <= 0 {
+		delay = verifyMaxDelay
+	}
+	if next = time.Now().Add(delay); next.After(job.Deadline) {
+		next = job.Deadline
+	}
+	return
+}
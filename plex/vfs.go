@@ -0,0 +1,123 @@
+package plex
+
+import (
+	"path"
+	"time"
+
+	"github.com/hekmon/rcgdip/rclonerc"
+)
+
+const (
+	mountOptionsSyncInterval = 10 * time.Minute
+)
+
+// syncMountOptions reads the poll interval and dir cache time the rclone mount is actually running with: they override the configured ones
+func (c *Controller) syncMountOptions() {
+	if c.rclone == nil || time.Since(c.optsSynced) < mountOptionsSyncInterval {
+		return
+	}
+	opts, err := c.rclone.GetVFSOptions(c.ctx)
+	if err != nil {
+		c.logger.Warningf("[Plex] failed to get the rclone mount options, keeping poll interval %v and dir cache time %v: %s", c.interval, c.dircache, err)
+		return
+	}
+	c.optsSynced = time.Now()
+	// Without polling, changes are only seen once the dir cache has expired
+	pollInterval := opts.PollInterval
+	if pollInterval == 0 {
+		pollInterval = opts.DirCacheTime
+	}
+	if pollInterval != c.interval {
+		if opts.PollInterval == 0 {
+			c.logger.Warningf("[Plex] the rclone mount has polling disabled while a poll interval of %v was configured: using its dir cache time (%v) instead",
+				c.interval, pollInterval)
+		} else {
+			c.logger.Warningf("[Plex] the rclone mount runs with a poll interval of %v while %v was configured: using the mount value",
+				opts.PollInterval, c.interval)
+		}
+		c.interval = pollInterval
+	}
+	if opts.DirCacheTime != c.dircache {
+		c.logger.Warningf("[Plex] the rclone mount runs with a dir cache time of %v while %v was configured: using the mount value",
+			opts.DirCacheTime, c.dircache)
+		c.dircache = opts.DirCacheTime
+	}
+}
+
+// refreshMount asks rclone to refresh its directory cache for the paths to scan: scans can then be launched right away
+func (c *Controller) refreshMount(scanList map[string]*scanTarget) {
+	if c.rclone == nil {
+		return
+	}
+	var (
+		err         error
+		rc          *rclonerc.Client
+		expectRC    *rclonerc.Client
+		vfsDir      string
+		vfsPath     string
+		ok          bool
+		forgetFiles []string
+		forgetDirs  []string
+	)
+	for localDir, target := range scanList {
+		if rc, vfsDir, ok = c.vfsTarget(localDir); !ok {
+			continue
+		}
+		// Forget deleted files and dirs
+		forgetFiles, forgetDirs = forgetFiles[:0], forgetDirs[:0]
+		for _, expect := range target.Expect {
+			if expect.Present {
+				continue
+			}
+			if expectRC, vfsPath, ok = c.vfsTarget(expect.Path); !ok || expectRC != rc {
+				continue
+			}
+			if expect.Folder {
+				forgetDirs = append(forgetDirs, vfsPath)
+			} else {
+				forgetFiles = append(forgetFiles, vfsPath)
+			}
+		}
+		if len(forgetFiles)+len(forgetDirs) > 0 {
+			if err = rc.VFSForget(c.ctx, forgetFiles, forgetDirs); err != nil {
+				c.logger.Warningf("[Plex] failed to forget deleted entries of '%s' within the rclone mount cache: %s", localDir, err)
+				continue
+			}
+		}
+		// Refresh the dir
+		if err = c.refreshVFSDir(rc, vfsDir, true); err != nil {
+			c.logger.Warningf("[Plex] failed to refresh '%s' within the rclone mount cache: %s", localDir, err)
+			continue
+		}
+		scanAt := time.Now().Add(waitTimeSafetyMargin).In(c.tz)
+		if scanAt.Before(target.ScanAt) {
+			c.logger.Infof("[Plex] '%s' refreshed within the rclone mount cache: scan moved from %v to %v", localDir, target.ScanAt, scanAt)
+			target.ScanAt = scanAt
+		}
+	}
+}
+
+func (c *Controller) refreshVFSDir(rc *rclonerc.Client, vfsDir string, climb bool) (err error) {
+	if err = rc.VFSRefresh(c.ctx, []string{vfsDir}); err == nil || !climb || vfsDir == "" {
+		return
+	}
+	// The dir might be new and unknown to its parent yet: refresh the parents first then try again
+	parent := path.Dir(vfsDir)
+	if parent == "." {
+		parent = ""
+	}
+	if c.refreshVFSDir(rc, parent, true) != nil {
+		return
+	}
+	return c.refreshVFSDir(rc, vfsDir, false)
+}
+
+// vfsTarget returns the rclone remote control client targeting the VFS of the mount root containing the local path
+// and the local path converted to a path relative to this VFS root
+func (c *Controller) vfsTarget(localPath string) (rc *rclonerc.Client, vfsPath string, ok bool) {
+	var root MountRoot
+	if root, vfsPath, ok = c.mountRootOf(localPath); ok {
+		rc = c.rcloneVFS[root.Local]
+	}
+	return
+}
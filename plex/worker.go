@@ -10,68 +10,74 @@ import (
 
 const (
 	waitTimeSafetyMargin = time.Second
+	batchRetryDelay      = 30 * time.Second
 )
 
-func (c *Controller) triggerWorker(input <-chan []drivechange.File) {
+func (c *Controller) triggerWorker(input Queue) {
 	// Prepare
 	defer c.workers.Done()
 	// Testing the plex connection
 	c.testPlexConnection()
-	// Launch all restored jobs (if any)
-	c.jobsAccess.Lock()
-	c.workers.Add(len(c.jobs))
-	for jobIndex, job := range c.jobs {
-		c.logger.Debugf("[Plex] starting restored job #%d: %s, %s, %v", jobIndex+1, job.LibName, job.ScanPath, job.ScanAt)
-		go c.jobExecutor(job)
-	}
-	c.jobs = nil
-	c.jobsAccess.Unlock()
+	// Use the actual mount options if we can
+	c.syncMountOptions()
 	// Wake up for work or stop
 	c.logger.Debug("[Plex] waiting for input")
+	var (
+		batchID uint64
+		batch   []drivechange.File
+		err     error
+	)
 	for {
-		select {
-		case batch := <-input:
-			c.workerPass(batch)
-		case <-c.ctx.Done():
-			c.logger.Debug("[Plex] stopping worker as main context has been cancelled")
-			return
+		if batchID, batch, err = input.Next(c.ctx); err != nil {
+			if c.ctx.Err() != nil {
+				c.logger.Debug("[Plex] stopping worker as main context has been cancelled")
+				return
+			}
+			// the batch can not be recovered, drop it to avoid being stuck on it
+			c.logger.Errorf("[Plex] failed to recover changes batch #%d from the queue, dropping it: %s", batchID, err)
+		} else if err = c.workerPass(batchID, batch); err != nil {
+			// keep the batch within the queue and retry later
+			c.logger.Errorf("[Plex] failed to process changes batch #%d, retrying in %v: %s", batchID, batchRetryDelay, err)
+			select {
+			case <-time.After(batchRetryDelay):
+				continue
+			case <-c.ctx.Done():
+				c.logger.Debug("[Plex] stopping worker as main context has been cancelled")
+				return
+			}
+		}
+		// Batch has been turned into jobs, remove it from the queue
+		if err = input.Ack(batchID); err != nil {
+			c.logger.Errorf("[Plex] failed to acknowledge changes batch #%d: %s", batchID, err)
 		}
 	}
 }
 
 func (c *Controller) testPlexConnection() {
-	// Get libs
-	libs, _, err := c.plex.GetLibraries(c.ctx)
-	if err != nil {
-		c.logger.Errorf("[Plex] failed to query the current libraries: %s", err.Error())
-		return
-	}
-	// Check libs locations
-	var (
-		nbPaths      int
-		nbCandidates int
-	)
-	for _, lib := range libs {
-		nbPaths += len(lib.Locations)
-		for _, location := range lib.Locations {
-			if strings.HasPrefix(location, c.mountPoint) {
-				nbCandidates++
-			}
-		}
-	}
-	if nbPaths == 0 {
-		c.logger.Warning("[Plex] no location found in any library: change events won't trigger any scan")
-	} else if nbCandidates == 0 {
-		c.logger.Warningf("[Plex] found %d libraries based on %d locations but none are based on rclone mount point '%s': change events won't trigger any scan",
-			len(libs), nbPaths, c.mountPoint)
-	} else {
-		c.logger.Infof("[Plex] found %d libraries based on %d locations on which %d are based on declared rclone mountpoint '%s'",
-			len(libs), nbPaths, nbCandidates, c.mountPoint)
+	// Get libs (and check them)
+	if err := c.refreshLibraries(); err != nil {
+		c.logger.Errorf("[Plex] %s", err)
 	}
 }
 
-func (c *Controller) workerPass(changes []drivechange.File) {
+// queuedBatch holds the jobs a batch has added to or updated within the queue
+type queuedBatch struct {
+	id        uint64
+	jobs      []*jobElement
+	deletions map[string]int
+}
+
+func (c *Controller) workerPass(batchID uint64, changes []drivechange.File) (err error) {
+	// Its jobs are already queued if the batch failed at persistence: merging them again would double their deletions
+	if c.unpersisted != nil {
+		if c.unpersisted.id == batchID {
+			return c.persistQueuedBatch()
+		}
+		c.unpersisted = nil
+	}
 	c.logger.Debugf("[Plex] received a batch of %d change(s)", len(changes))
+	// Make sure our predictions are based on the current mount options
+	c.syncMountOptions()
 	// Build uniq fully qualified folder paths to scan
 	scanList := c.extractBasePathsToScan(changes)
 	if c.logger.IsDebugShown() {
@@ -85,48 +91,131 @@ func (c *Controller) workerPass(changes []drivechange.File) {
 			c.logger.Debugf("[Plex] the following %d path(s) need scanning: %s", len(paths), strings.Join(paths, ", "))
 		}
 	}
+	// Ask the rclone mount to refresh these paths if possible
+	c.refreshMount(scanList)
 	// Get plex libs
-	libs, _, err := c.plex.GetLibraries(c.ctx)
+	libs, locations, err := c.getLibraries()
 	if err != nil {
-		c.logger.Errorf("[Plex] failed to query the current libraries, aborting this batch: %s", err.Error())
 		return
 	}
 	// Create scan jobs for each path if we can
+	var (
+		pathJobs  []*jobElement
+		refreshed bool
+	)
 	jobs := make([]*jobElement, 0, len(scanList)*len(libs))
-	for path, eventTime := range scanList {
-		jobs = append(jobs, c.generateJobsDefinition(path, eventTime, libs)...)
+	for path, target := range scanList {
+		pathJobs = c.generateJobsDefinition(path, target, libs, locations)
+		if len(pathJobs) == 0 && !refreshed && time.Since(c.libraries.updatedAt) >= librariesMinRefreshDelay {
+			// the path might be within a library or a location we do not know yet
+			refreshed = true
+			if refreshErr := c.refreshLibraries(); refreshErr != nil {
+				c.logger.Warningf("[Plex] no library found for '%s' and %s", path, refreshErr)
+			} else {
+				libs, locations = c.libraries.selected, c.libraries.locations
+				pathJobs = c.generateJobsDefinition(path, target, libs, locations)
+			}
+		}
+		jobs = append(jobs, pathJobs...)
 	}
 	c.logger.Debugf("[Plex] created %d scan job(s)", len(jobs))
 	// Optimize scan jobs (remove child paths if parents path are also scheduled within the same library)
 	jobs = c.consolidateAndOptimize(jobs)
-	// Start or schedule the jobs
-	c.workers.Add(len(jobs))
-	for jobIndex, job := range jobs {
-		c.logger.Debugf("[Plex] launching job #%d: scheduling scan of '%s' in '%s' at %v", jobIndex+1, job.ScanPath, job.LibName, job.ScanAt)
-		go c.jobExecutor(job)
+	// Hold the deletion driven jobs if this batch looks like a mass deletion
+	var deletions map[string]int
+	if jobs, deletions, err = c.applyDeletionBrake(jobs, libs); err != nil {
+		return
+	}
+	// If we can verify the mount, start checking it right away and only use the predicted time as deadline
+	if c.verify {
+		now := time.Now()
+		for _, job := range jobs {
+			job.Deadline = job.ScanAt
+			job.ScanAt = now
+		}
+	}
+	// Schedule the jobs (merging them with the already scheduled ones)
+	c.queueAccess.Lock()
+	c.unpersisted = &queuedBatch{
+		id:        batchID,
+		jobs:      c.queueJobs(jobs),
+		deletions: deletions,
+	}
+	c.queueAccess.Unlock()
+	return c.persistQueuedBatch()
+}
+
+// persistQueuedBatch saves the jobs of the queued batch and records its deletions once done
+func (c *Controller) persistQueuedBatch() (err error) {
+	c.queueAccess.Lock()
+	err = c.persistJobs(c.unpersisted.jobs)
+	c.queueAccess.Unlock()
+	if err != nil {
+		return
+	}
+	c.recordDeletions(c.unpersisted.deletions)
+	c.unpersisted = nil
+	return
+}
+
+type scanTarget struct {
+	ScanAt    time.Time
+	Expect    []pathExpectation
+	Deletions int
+	Moves     []movedAway
+}
+
+// movedAway is a removal which only counts as a deletion for the libraries its destinations are not part of
+type movedAway struct {
+	Files int
+	To    []string // local paths
+}
+
+func (target *scanTarget) addRemoval(change drivechange.File, files int, destinations map[string][]string) {
+	if files == 0 {
+		return
+	}
+	if change.Moved {
+		target.Moves = append(target.Moves, movedAway{
+			Files: files,
+			To:    destinations[change.ID],
+		})
+	} else {
+		target.Deletions += files
 	}
 }
 
-func (c *Controller) extractBasePathsToScan(changes []drivechange.File) (scanList map[string]time.Time) {
+func (c *Controller) extractBasePathsToScan(changes []drivechange.File) (scanList map[string]*scanTarget) {
 	// Extract uniq parents to scan for file changes
 	var (
-		nbPaths                  int
-		found                    bool
-		waitUntil                time.Time
-		alreadyScheduledPathTime time.Time
+		nbPaths          int
+		waitUntil        time.Time
+		alreadyScheduled *scanTarget
 	)
+	// Index where the files are now (to know if the moved ones have left their libraries) and the removed folders (their content is counted with them)
+	var (
+		localPath string
+		ok        bool
+	)
+	destinations := make(map[string][]string)
+	removedFolders := newPathTrie()
 	for _, change := range changes {
 		nbPaths += len(change.Paths)
-	}
-	scanList = make(map[string]time.Time, nbPaths)
-	nbPaths = 0
-	for _, change := range changes {
 		for _, changePath := range change.Paths {
-			// Do not process folders not deleted
-			if change.Folder && !change.Deleted {
-				c.logger.Infof("[Plex] skipping folder change not being deletion: %s", changePath)
+			if localPath, ok = c.localPath(changePath); !ok {
 				continue
 			}
+			if !change.Deleted && change.ID != "" {
+				destinations[change.ID] = append(destinations[change.ID], localPath)
+			} else if change.Deleted && change.Folder {
+				removedFolders.insert(localPath, 0)
+			}
+		}
+	}
+	scanList = make(map[string]*scanTarget, nbPaths)
+	var removedFiles int
+	for _, change := range changes {
+		for _, changePath := range change.Paths {
 			// Compute the time when we will be able to start the scan (+ a safety marging)
 			if change.Deleted {
 				// rclone will only see it after its dir cache time is elapsed
@@ -135,34 +224,74 @@ func (c *Controller) extractBasePathsToScan(changes []drivechange.File) (scanLis
 				// rclone will see it within its PollInterval
 				waitUntil = change.Event.Add(c.interval + waitTimeSafetyMargin).In(c.tz)
 			}
-			// Schedule scan for parent folder
-			parent := path.Join(c.mountPoint, path.Dir(changePath))
-			if alreadyScheduledPathTime, found = scanList[parent]; !found {
+			// Schedule scan for parent folder (or the folder itself if it has been created, moved or renamed: only its content matters)
+			if localPath, ok = c.localPath(changePath); !ok {
+				c.logger.Debugf("[Plex] path '%s' is not within any mount root: skipping", changePath)
+				continue
+			}
+			parent := path.Dir(localPath)
+			if change.Folder && !change.Deleted {
+				parent = localPath
+			}
+			expect := pathExpectation{
+				Path:    localPath,
+				Present: !change.Deleted,
+				Folder:  change.Folder,
+			}
+			// Count the removed files for the mass deletion brake
+			removedFiles = 0
+			if change.Deleted && len(removedFolders.ancestors(path.Dir(localPath))) == 0 {
+				if change.Folder {
+					removedFiles = change.Files
+				} else {
+					removedFiles = 1
+				}
+			}
+			if alreadyScheduled = scanList[parent]; alreadyScheduled == nil {
 				// parent path is new, add it to the list
-				scanList[parent] = waitUntil
+				scanList[parent] = &scanTarget{
+					ScanAt: waitUntil,
+					Expect: []pathExpectation{expect},
+				}
+				scanList[parent].addRemoval(change, removedFiles, destinations)
 				// Debug log
 				if c.logger.IsInfoShown() {
-					if change.Folder { // and deleted ofc
-						c.logger.Infof("[Plex] folder '%s' deleted, adding its local parent to scan list: %s", changePath, parent)
+					var fileType, action string
+					if change.Folder {
+						fileType = "folder"
+					} else {
+						fileType = "file"
+					}
+					if change.Moved {
+						action = "moved or renamed away"
+					} else if change.Deleted {
+						action = "deleted"
+					} else if change.Folder {
+						action = "created, moved or renamed"
 					} else {
-						var action string
-						if change.Deleted {
-							action = "deleted"
-						} else {
-							action = "created or changed"
-						}
-						c.logger.Infof("[Plex] file '%s' %s, adding its local parent to scan list: %s", changePath, action, parent)
+						action = "created or changed"
+					}
+					if parent == localPath {
+						c.logger.Infof("[Plex] %s '%s' %s, adding it to scan list: %s", fileType, changePath, action, parent)
+					} else {
+						c.logger.Infof("[Plex] %s '%s' %s, adding its local parent to scan list: %s", fileType, changePath, action, parent)
 					}
 				}
-			} else if alreadyScheduledPathTime.Before(waitUntil) {
+				continue
+			}
+			if len(alreadyScheduled.Expect) < maxExpectationsPerJob {
+				alreadyScheduled.Expect = append(alreadyScheduled.Expect, expect)
+			}
+			alreadyScheduled.addRemoval(change, removedFiles, destinations)
+			if alreadyScheduled.ScanAt.Before(waitUntil) {
 				// current event is fresher than the one previously registered for this path, it means we need to wait longer to see it locally:
 				// always use the one we need to wait for the most to avoid not seeing some files by scanning too early
 				c.logger.Debugf("[Plex] path '%s' was already registered for scan for event at %v. But this new event is younger, replacing time: %v",
-					parent, alreadyScheduledPathTime, waitUntil)
-				scanList[parent] = waitUntil
+					parent, alreadyScheduled.ScanAt, waitUntil)
+				alreadyScheduled.ScanAt = waitUntil
 			} else {
 				c.logger.Debugf("[Plex] path '%s' is already registered for scan for event at %v. Skipping current event at %v",
-					parent, alreadyScheduledPathTime, waitUntil)
+					parent, alreadyScheduled.ScanAt, waitUntil)
 			}
 		}
 	}
@@ -170,44 +299,34 @@ func (c *Controller) extractBasePathsToScan(changes []drivechange.File) (scanLis
 }
 
 func (c *Controller) consolidateAndOptimize(jobs []*jobElement) (consolidatedJobs []*jobElement) {
-	// Detect if some paths are included within parents scheduled for scan
-	indexesToRemove := make(map[int]struct{}, len(jobs))
-	for potentialParentIndex, potentialParent := range jobs {
-		for potentialChildIndex, potentialChild := range jobs {
-			// do not compare against self
-			if potentialParentIndex == potentialChildIndex {
-				continue
-			}
-			// only consolidate within the same library
-			if potentialParent.LibKey != potentialChild.LibKey {
-				continue
-			}
-			// check path to seek optimisation
-			if strings.HasPrefix(potentialChild.ScanPath, potentialParent.ScanPath) {
-				c.logger.Debugf("[Plex] library '%s': path '%s' remove from scan list: its parent '%s' is already scheduled for scan",
-					potentialChild.LibName, potentialChild.ScanPath, potentialParent.ScanPath)
-				indexesToRemove[potentialChildIndex] = struct{}{}
-				// If child was to be scanned later than parent, delay the parent to allow both of them to appear on the mount
-				if potentialChild.ScanAt.After(potentialParent.ScanAt) {
-					c.logger.Debugf("[Plex] library '%s': delaying the scan of the parent '%s' (event at %v) because the removed child path (%s) to be scan was scheduled later (event at %v)",
-						potentialChild.LibName, potentialParent.ScanPath, potentialParent.ScanAt, potentialChild.ScanPath, potentialChild.ScanAt)
-					potentialParent.ScanAt = potentialChild.ScanAt
-				}
-			}
+	// Index the jobs paths, one tree per library
+	libsTries := make(map[string]*pathTrie)
+	for index, job := range jobs {
+		trie := libsTries[job.LibKey]
+		if trie == nil {
+			trie = newPathTrie()
+			libsTries[job.LibKey] = trie
 		}
+		trie.insert(job.ScanPath, index)
 	}
-	// Now that we know the indexes to remove, build the final list
-	var (
-		consolidatedJobsCounter int
-		found                   bool
-	)
-	consolidatedJobs = make([]*jobElement, len(jobs)-len(indexesToRemove))
-	for jobIndex, job := range jobs {
-		if _, found = indexesToRemove[jobIndex]; found {
+	// Detect if some paths are included within parents scheduled for scan (the top most one survives)
+	consolidatedJobs = make([]*jobElement, 0, len(jobs))
+	for index, job := range jobs {
+		parentIndex := libsTries[job.LibKey].ancestors(job.ScanPath)[0]
+		if parentIndex == index {
+			consolidatedJobs = append(consolidatedJobs, job)
 			continue
 		}
-		consolidatedJobs[consolidatedJobsCounter] = job
-		consolidatedJobsCounter++
+		parent := jobs[parentIndex]
+		c.logger.Debugf("[Plex] library '%s': path '%s' remove from scan list: its parent '%s' is already scheduled for scan",
+			job.LibName, job.ScanPath, parent.ScanPath)
+		parent.absorb(job, false)
+		// If child was to be scanned later than parent, delay the parent to allow both of them to appear on the mount
+		if job.ScanAt.After(parent.ScanAt) {
+			c.logger.Debugf("[Plex] library '%s': delaying the scan of the parent '%s' (event at %v) because the removed child path (%s) to be scan was scheduled later (event at %v)",
+				job.LibName, parent.ScanPath, parent.ScanAt, job.ScanPath, job.ScanAt)
+			parent.ScanAt = job.ScanAt
+		}
 	}
 	return
 }
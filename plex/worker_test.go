@@ -0,0 +1,104 @@
+package plex
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hekmon/hllogger/v2"
+)
+
+// memStorage is a state keeping the values as is, which can be set to fail
+type memStorage struct {
+	values map[string]interface{}
+	broken bool
+}
+
+func (ms *memStorage) Clear() error {
+	ms.values = make(map[string]interface{})
+	return nil
+}
+
+func (ms *memStorage) Delete(key string) error {
+	delete(ms.values, key)
+	return nil
+}
+
+func (ms *memStorage) Get(key string, value interface{}) (bool, error) {
+	_, found := ms.values[key]
+	return found, nil
+}
+
+func (ms *memStorage) Has(key string) bool {
+	_, found := ms.values[key]
+	return found
+}
+
+func (ms *memStorage) Keys() (keys []string) {
+	for key := range ms.values {
+		keys = append(keys, key)
+	}
+	return
+}
+
+func (ms *memStorage) NbKeys() int {
+	return len(ms.values)
+}
+
+func (ms *memStorage) Set(key string, value interface{}) error {
+	if ms.broken {
+		return errors.New("storage is broken")
+	}
+	ms.values[key] = value
+	return nil
+}
+
+func (ms *memStorage) Sync() error {
+	return nil
+}
+
+func TestWorkerPassPersistenceRetry(t *testing.T) {
+	state := &memStorage{values: make(map[string]interface{})}
+	c := &Controller{
+		logger:       hllogger.New(ioutil.Discard, hllogger.Debug),
+		state:        state,
+		pending:      make(map[string]*jobsTrie),
+		brakeRecords: make(map[string][]deletionsRecord),
+	}
+	now := time.Now()
+	pending := &jobElement{LibKey: "1", ScanPath: "/mnt/Movies/Movie (2022)", ScanAt: now, Deletions: 1}
+	c.pushJob(pending)
+	// The batch jobs are queued but their persistence fails
+	state.broken = true
+	c.queueAccess.Lock()
+	c.unpersisted = &queuedBatch{
+		id:        7,
+		jobs:      c.queueJobs([]*jobElement{{LibKey: "1", ScanPath: "/mnt/Movies/Movie (2022)/Extras", ScanAt: now, Deletions: 2}}),
+		deletions: map[string]int{"1": 2},
+	}
+	c.queueAccess.Unlock()
+	if err := c.persistQueuedBatch(); err == nil {
+		t.Fatal("expected the persistence of the batch to fail")
+	}
+	if len(c.brakeRecords) != 0 {
+		t.Errorf("expected no deletions to be recorded before the batch is persisted, got %v", c.brakeRecords)
+	}
+	// Processing the same batch again only retries its persistence
+	state.broken = false
+	if err := c.workerPass(7, nil); err != nil {
+		t.Fatalf("expected the batch to be persisted, got %s", err)
+	}
+	if len(c.queue) != 1 || pending.Deletions != 3 {
+		t.Errorf("expected the batch to be merged once (1 job with 3 deletions), got %d job(s) and %d deletion(s)", len(c.queue), pending.Deletions)
+	}
+	if !state.Has(stateJobPrefix + pending.ID) {
+		t.Errorf("expected the updated job to be persisted")
+	}
+	if records := c.brakeRecords["1"]; len(records) != 1 || records[0].count != 2 {
+		t.Errorf("expected the batch deletions to be recorded once, got %v", records)
+	}
+	if c.unpersisted != nil {
+		t.Errorf("expected no batch to be left for persistence")
+	}
+}
@@ -0,0 +1,32 @@
+package rclonerc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// VFSOptions contains the VFS options of the rclone instance relevant to predict when a change will be visible on the mount
+type VFSOptions struct {
+	DirCacheTime time.Duration `json:"DirCacheTime"`
+	PollInterval time.Duration `json:"PollInterval"`
+}
+
+// GetVFSOptions returns the VFS options the rclone instance is currently running with
+func (c *Client) GetVFSOptions(ctx context.Context) (opts VFSOptions, err error) {
+	var answerPayload struct {
+		VFS *VFSOptions `json:"vfs"`
+	}
+	// Execute request
+	if err = c.request(ctx, "options/get", nil, &answerPayload); err != nil {
+		err = fmt.Errorf("failed to execute options get query: %w", err)
+		return
+	}
+	if answerPayload.VFS == nil {
+		err = errors.New("no VFS options found within the answer: is it a mount ?")
+		return
+	}
+	opts = *answerPayload.VFS
+	return
+}
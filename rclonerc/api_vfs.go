@@ -0,0 +1,62 @@
+package rclonerc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VFSRefresh reads again the given directories (paths relative to the VFS root) from the backend
+func (c *Client) VFSRefresh(ctx context.Context, dirs []string) (err error) {
+	// Prepare request
+	params := c.vfsParams(len(dirs))
+	for index, dir := range dirs {
+		params[fmt.Sprintf("dir%d", index)] = dir
+	}
+	var answerPayload struct {
+		Result map[string]string `json:"result"`
+	}
+	// Execute request
+	if err = c.request(ctx, "vfs/refresh", params, &answerPayload); err != nil {
+		err = fmt.Errorf("failed to execute vfs refresh query: %w", err)
+		return
+	}
+	// Each dir has its own result
+	var failed []string
+	for dir, result := range answerPayload.Result {
+		if result != "OK" {
+			failed = append(failed, fmt.Sprintf("'%s': %s", dir, result))
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("failed to refresh %d dir(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return
+}
+
+// VFSForget removes the given files and directories (paths relative to the VFS root) from the directory cache
+func (c *Client) VFSForget(ctx context.Context, files, dirs []string) (err error) {
+	// Prepare request
+	params := c.vfsParams(len(files) + len(dirs))
+	for index, file := range files {
+		params[fmt.Sprintf("file%d", index)] = file
+	}
+	for index, dir := range dirs {
+		params[fmt.Sprintf("dir%d", index)] = dir
+	}
+	// Execute request
+	if err = c.request(ctx, "vfs/forget", params, nil); err != nil {
+		err = fmt.Errorf("failed to execute vfs forget query: %w", err)
+		return
+	}
+	return
+}
+
+// vfsParams targets the configured VFS when the rclone instance runs several mounts
+func (c *Client) vfsParams(size int) (params map[string]string) {
+	params = make(map[string]string, size+1)
+	if c.fs != "" {
+		params["fs"] = c.fs
+	}
+	return
+}
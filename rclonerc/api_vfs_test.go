@@ -0,0 +1,109 @@
+package rclonerc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVFSRefresh(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fs     string
+		dirs   []string
+		answer string
+		params map[string]string
+		failed []string
+	}{
+		{
+			name:   "ok",
+			dirs:   []string{"Movies", "TV Shows/Show"},
+			answer: `{"result":{"Movies":"OK","TV Shows/Show":"OK"}}`,
+			params: map[string]string{"dir0": "Movies", "dir1": "TV Shows/Show"},
+		},
+		{
+			name:   "fs",
+			fs:     "gdrive:",
+			dirs:   []string{"Movies"},
+			answer: `{"result":{"Movies":"OK"}}`,
+			params: map[string]string{"fs": "gdrive:", "dir0": "Movies"},
+		},
+		{
+			name:   "partial failure",
+			dirs:   []string{"Movies", "Missing", "Other"},
+			answer: `{"result":{"Movies":"OK","Missing":"file does not exist","Other":"OK"}}`,
+			params: map[string]string{"dir0": "Movies", "dir1": "Missing", "dir2": "Other"},
+			failed: []string{"'Missing': file does not exist"},
+		},
+	}
+	rc := newFakeRC(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc.answer = tc.answer
+			err := rc.client(t, Config{FS: tc.fs}).VFSRefresh(context.Background(), tc.dirs)
+			if rc.endpoint != "vfs/refresh" {
+				t.Errorf("endpoint: expected 'vfs/refresh', got '%s'", rc.endpoint)
+			}
+			if !reflect.DeepEqual(rc.params, tc.params) {
+				t.Errorf("params: expected %v, got %v", tc.params, rc.params)
+			}
+			if len(tc.failed) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, failed := range tc.failed {
+				if !strings.Contains(err.Error(), failed) {
+					t.Errorf("expected the error to contain %s, got '%s'", failed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVFSForget(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fs     string
+		files  []string
+		dirs   []string
+		params map[string]string
+	}{
+		{
+			name:   "files and dirs",
+			files:  []string{"Movies/Movie (2022)/Movie.mkv", "Movies/Other.mkv"},
+			dirs:   []string{"Movies/Movie (2022)"},
+			params: map[string]string{"file0": "Movies/Movie (2022)/Movie.mkv", "file1": "Movies/Other.mkv", "dir0": "Movies/Movie (2022)"},
+		},
+		{
+			name:   "fs",
+			fs:     "gdrive:",
+			dirs:   []string{"Movies"},
+			params: map[string]string{"fs": "gdrive:", "dir0": "Movies"},
+		},
+		{
+			name:   "nothing",
+			params: map[string]string{},
+		},
+	}
+	rc := newFakeRC(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc.answer = `{"forgotten":[]}`
+			if err := rc.client(t, Config{FS: tc.fs}).VFSForget(context.Background(), tc.files, tc.dirs); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rc.endpoint != "vfs/forget" {
+				t.Errorf("endpoint: expected 'vfs/forget', got '%s'", rc.endpoint)
+			}
+			if !reflect.DeepEqual(rc.params, tc.params) {
+				t.Errorf("params: expected %v, got %v", tc.params, rc.params)
+			}
+		})
+	}
+}
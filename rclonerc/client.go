@@ -0,0 +1,55 @@
+package rclonerc
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+type Config struct {
+	// Base config
+	BaseURL *url.URL
+	User    string
+	Pass    string
+	// Advanced config
+	FS           string // VFS to target when the rclone instance runs several mounts, eg "gdrive:"
+	CustomClient *http.Client
+}
+
+type Client struct {
+	// User config
+	baseURL *url.URL
+	user    string
+	pass    string
+	fs      string
+	// Controller
+	http *http.Client
+}
+
+func New(conf Config) (c *Client, err error) {
+	defer func() {
+		if err != nil {
+			c = nil
+		}
+	}()
+	// Base init
+	if conf.CustomClient == nil {
+		conf.CustomClient = http.DefaultClient
+	}
+	c = &Client{
+		user: conf.User,
+		pass: conf.Pass,
+		fs:   conf.FS,
+		http: conf.CustomClient,
+	}
+	// Validate base URL
+	if conf.BaseURL == nil {
+		err = errors.New("base URL can not be nil")
+		return
+	}
+	c.baseURL = conf.BaseURL
+	if len(c.baseURL.Path) > 0 && c.baseURL.Path[len(c.baseURL.Path)-1] == '/' {
+		c.baseURL.Path = c.baseURL.Path[:len(c.baseURL.Path)-1]
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package rclonerc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type errorPayload struct {
+	Error string `json:"error"`
+	Path  string `json:"path"`
+}
+
+func (c *Client) request(ctx context.Context, endpoint string, params map[string]string, output interface{}) (err error) {
+	// Prepare URL and payload
+	requestURL := *c.baseURL
+	requestURL.Path += "/" + endpoint
+	if params == nil {
+		params = make(map[string]string)
+	}
+	payload, err := json.Marshal(params)
+	if err != nil {
+		err = fmt.Errorf("failed to marshal parameters as JSON: %w", err)
+		return
+	}
+	// Build HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL.String(), bytes.NewReader(payload))
+	if err != nil {
+		err = fmt.Errorf("failed to build HTTP query: %w", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.user != "" || c.pass != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	// Execute HTTP request
+	resp, err := c.http.Do(req)
+	if err != nil {
+		err = fmt.Errorf("failed to execute HTTP query: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		var errPayload errorPayload
+		if json.NewDecoder(resp.Body).Decode(&errPayload) == nil && errPayload.Error != "" {
+			err = fmt.Errorf("request status error: %s: %s", resp.Status, errPayload.Error)
+		} else {
+			err = fmt.Errorf("request status error: %s", resp.Status)
+		}
+		return
+	}
+	// Unmarshall
+	if output != nil {
+		if err = json.NewDecoder(resp.Body).Decode(output); err != nil {
+			err = fmt.Errorf("failed to decode response payload as JSON: %w", err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package rclonerc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeRC mimics an rclone remote control server and records the last request it received
+type fakeRC struct {
+	*httptest.Server
+	// answer
+	statusCode int
+	answer     string
+	// last request
+	endpoint string
+	params   map[string]string
+	user     string
+	pass     string
+	authSent bool
+}
+
+func newFakeRC(t *testing.T) (rc *fakeRC) {
+	rc = &fakeRC{
+		statusCode: http.StatusOK,
+		answer:     "{}",
+	}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("rc server: unexpected method: %s", r.Method)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("rc server: unexpected content type: %s", contentType)
+		}
+		rc.endpoint = strings.TrimPrefix(r.URL.Path, "/")
+		rc.params = nil
+		if err := json.NewDecoder(r.Body).Decode(&rc.params); err != nil {
+			t.Errorf("rc server: failed to decode the parameters: %s", err)
+		}
+		rc.user, rc.pass, rc.authSent = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rc.statusCode)
+		w.Write([]byte(rc.answer))
+	}))
+	t.Cleanup(rc.Close)
+	return
+}
+
+func (rc *fakeRC) client(t *testing.T, conf Config) (c *Client) {
+	baseURL, err := url.Parse(rc.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse the rc server URL: %s", err)
+	}
+	conf.BaseURL = baseURL
+	if c, err = New(conf); err != nil {
+		t.Fatalf("failed to create the client: %s", err)
+	}
+	return
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("expected an error without base URL")
+	}
+}
+
+func TestRequestBasicAuth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		user     string
+		pass     string
+		authSent bool
+	}{
+		{name: "no auth"},
+		{name: "user and pass", user: "user", pass: "pass", authSent: true},
+		{name: "user only", user: "user", authSent: true},
+	}
+	rc := newFakeRC(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := rc.client(t, Config{User: tc.user, Pass: tc.pass})
+			if err := c.request(context.Background(), "rc/noop", nil, nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rc.endpoint != "rc/noop" {
+				t.Errorf("endpoint: expected 'rc/noop', got '%s'", rc.endpoint)
+			}
+			if rc.authSent != tc.authSent {
+				t.Fatalf("basic auth sent: expected %v, got %v", tc.authSent, rc.authSent)
+			}
+			if rc.user != tc.user || rc.pass != tc.pass {
+				t.Errorf("basic auth: expected '%s'/'%s', got '%s'/'%s'", tc.user, tc.pass, rc.user, rc.pass)
+			}
+		})
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		answer     string
+		err        string
+	}{
+		{
+			name:       "error payload",
+			statusCode: http.StatusInternalServerError,
+			answer:     `{"error":"dir not found","input":{},"path":"vfs/refresh","status":500}`,
+			err:        "request status error: 500 Internal Server Error: dir not found",
+		},
+		{
+			name:       "no error payload",
+			statusCode: http.StatusNotFound,
+			answer:     "404 page not found",
+			err:        "request status error: 404 Not Found",
+		},
+		{
+			name:       "invalid answer",
+			statusCode: http.StatusOK,
+			answer:     "{",
+			err:        "failed to decode response payload as JSON",
+		},
+	}
+	rc := newFakeRC(t)
+	c := rc.client(t, Config{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc.statusCode = tc.statusCode
+			rc.answer = tc.answer
+			var output map[string]interface{}
+			err := c.request(context.Background(), "vfs/refresh", nil, &output)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("expected an error starting with '%s', got '%s'", tc.err, err)
+			}
+		})
+	}
+}
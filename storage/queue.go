@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hekmon/rcgdip/drivechange"
+
+	"github.com/hekmon/hllogger/v2"
+)
+
+const (
+	queueHeadKey     = "head"
+	queueTailKey     = "tail"
+	queueBatchPrefix = "batch_"
+)
+
+// ChangesQueue is a durable FIFO of changes batches: a batch stays within the db until it is acknowledged
+type ChangesQueue struct {
+	realm  *RealmController
+	logger *hllogger.Logger
+	access sync.Mutex
+	head   uint64 // next batch to consume
+	tail   uint64 // next batch ID to assign
+	notify chan struct{}
+}
+
+func (c *Controller) NewChangesQueue(realm string) (q *ChangesQueue, err error) {
+	q = &ChangesQueue{
+		realm:  c.NewScoppedAccess(realm),
+		logger: c.logger,
+		notify: make(chan struct{}, 1),
+	}
+	// Restore pointers
+	if _, err = q.realm.Get(queueHeadKey, &q.head); err != nil {
+		err = fmt.Errorf("failed to restore the queue head: %w", err)
+		return
+	}
+	if _, err = q.realm.Get(queueTailKey, &q.tail); err != nil {
+		err = fmt.Errorf("failed to restore the queue tail: %w", err)
+		return
+	}
+	if q.tail < q.head {
+		err = fmt.Errorf("queue is inconsistent: tail (%d) is behind head (%d)", q.tail, q.head)
+		return
+	}
+	if q.tail > q.head {
+		c.logger.Infof("[Storage] %d unacknowledged changes batch(es) restored in the '%s' queue", q.tail-q.head, realm)
+		q.notify <- struct{}{}
+	}
+	return
+}
+
+// Enqueue returns once the batch has been durably written
+func (q *ChangesQueue) Enqueue(batch []drivechange.File) (err error) {
+	q.access.Lock()
+	defer q.access.Unlock()
+	id := q.tail
+	// Write each file individually to stay under the max value size
+	for index, file := range batch {
+		if err = q.realm.Set(batchFileKey(id, index), file); err != nil {
+			err = fmt.Errorf("failed to write file #%d of batch #%d: %w", index, id, err)
+			return
+		}
+	}
+	if err = q.realm.Set(batchLenKey(id), len(batch)); err != nil {
+		err = fmt.Errorf("failed to write the length of batch #%d: %w", id, err)
+		return
+	}
+	// Commit the batch by moving the tail
+	if err = q.realm.Set(queueTailKey, id+1); err != nil {
+		err = fmt.Errorf("failed to move the queue tail: %w", err)
+		return
+	}
+	if err = q.realm.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync the db: %w", err)
+		return
+	}
+	q.tail = id + 1
+	// Wake up the consumer
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// Next blocks until a batch is available and returns it without removing it from the queue: use Ack once processed
+func (q *ChangesQueue) Next(ctx context.Context) (id uint64, batch []drivechange.File, err error) {
+	for {
+		q.access.Lock()
+		if q.head < q.tail {
+			id = q.head
+			batch, err = q.load(id)
+			q.access.Unlock()
+			return
+		}
+		q.access.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// Ack removes the batch from the queue, it must be the one returned by Next
+func (q *ChangesQueue) Ack(id uint64) (err error) {
+	q.access.Lock()
+	defer q.access.Unlock()
+	if id != q.head {
+		return fmt.Errorf("can not acknowledge batch #%d: current head is #%d", id, q.head)
+	}
+	// Move the head first: a crash while cleaning up only leaves orphan keys behind
+	if err = q.realm.Set(queueHeadKey, id+1); err != nil {
+		err = fmt.Errorf("failed to move the queue head: %w", err)
+		return
+	}
+	if err = q.realm.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync the db: %w", err)
+		return
+	}
+	q.head = id + 1
+	// Clean up
+	var length int
+	if _, err = q.realm.Get(batchLenKey(id), &length); err != nil {
+		q.logger.Warningf("[Storage] failed to get the length of acknowledged batch #%d, its files will stay in the db: %s", id, err)
+		err = nil
+		return
+	}
+	for index := 0; index < length; index++ {
+		if err = q.realm.Delete(batchFileKey(id, index)); err != nil {
+			q.logger.Warningf("[Storage] failed to delete file #%d of acknowledged batch #%d: %s", index, id, err)
+		}
+	}
+	if err = q.realm.Delete(batchLenKey(id)); err != nil {
+		q.logger.Warningf("[Storage] failed to delete the length of acknowledged batch #%d: %s", id, err)
+	}
+	err = nil
+	return
+}
+
+// Len returns the number of unacknowledged batches
+func (q *ChangesQueue) Len() int {
+	q.access.Lock()
+	defer q.access.Unlock()
+	return int(q.tail - q.head)
+}
+
+func (q *ChangesQueue) load(id uint64) (batch []drivechange.File, err error) {
+	var (
+		length int
+		found  bool
+	)
+	if found, err = q.realm.Get(batchLenKey(id), &length); err != nil {
+		err = fmt.Errorf("failed to get the length of batch #%d: %w", id, err)
+		return
+	}
+	if !found {
+		err = fmt.Errorf("length of batch #%d not found (is db inconsistent ?)", id)
+		return
+	}
+	batch = make([]drivechange.File, length)
+	for index := range batch {
+		if found, err = q.realm.Get(batchFileKey(id, index), &batch[index]); err != nil {
+			err = fmt.Errorf("failed to get file #%d of batch #%d: %w", index, id, err)
+			return
+		}
+		if !found {
+			err = fmt.Errorf("file #%d of batch #%d not found (is db inconsistent ?)", index, id)
+			return
+		}
+	}
+	return
+}
+
+func batchLenKey(id uint64) string {
+	return fmt.Sprintf("%s%d_len", queueBatchPrefix, id)
+}
+
+func batchFileKey(id uint64, index int) string {
+	return fmt.Sprintf("%s%d_%d", queueBatchPrefix, id, index)
+}
@@ -72,6 +72,15 @@ func (sb *RealmController) Keys() (keys []string) {
 	return
 }
 
+// KeysWithPrefix only walks the keys starting with prefix, unlike Keys
+func (sb *RealmController) KeysWithPrefix(prefix string) (keys []string, err error) {
+	err = sb.main.db.Scan(sb.fqdnKey(prefix), func(key []byte) error {
+		keys = append(keys, string(key[len(sb.prefix):]))
+		return nil
+	})
+	return
+}
+
 func (sb *RealmController) NbKeys() (nbKeys int) {
 	for key := range sb.main.db.Keys() {
 		if sb.hasKeyPrefix(key) {